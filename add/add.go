@@ -10,17 +10,20 @@ import (
 	"github.com/vube/depman/colors"
 	"github.com/vube/depman/dep"
 	"github.com/vube/depman/install"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
 	"github.com/vube/depman/util"
 )
 
-// Add interactively prompts the user for details of a dependency, adds it to deps.json, writes out the file, and installs the dependencies
-func Add(deps dep.DependencyMap, name string) {
+// Add interactively prompts the user for details of a dependency, adds it to deps.json, writes out the file, and installs the dependencies.
+// Progress is reported to r
+func Add(deps dep.DependencyMap, name string, r report.Reporter) {
 	_, exists := deps.Map[name]
 	if exists {
-		util.Fatal(colors.Red("Dependency '" + name + "'' is already defined, pick another name."))
+		util.Abort(result.Config, "Dependency '"+name+"'' is already defined, pick another name.")
 	}
 
-	util.Print(colors.Blue("Adding: ") + name)
+	r.Info(report.NoIndent, colors.Blue("Adding: ")+name)
 
 	d := new(dep.Dependency)
 	d.Type = promptType("Type", "git, git-clone, hg, bzr")
@@ -51,7 +54,7 @@ func Add(deps dep.DependencyMap, name string) {
 		util.Fatal(colors.Red("Error Writing " + deps.Path + ": " + err.Error()))
 	}
 
-	install.Install(deps)
+	install.Install(deps, r)
 
 	return
 }
@@ -0,0 +1,343 @@
+// Package api lets other Go programs install and inspect the dependencies in a deps.json, without the command line.
+// The packages of depman are configured by package level settings, so Install, Freeze and Status apply the Options
+// they are given and only one of them runs at a time. They never exit the process, errors are returned.
+// Progress is reported to Options.Reporter, colors can be disabled with colors.SetEnabled
+package api
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/install"
+	"github.com/vube/depman/mirror"
+	"github.com/vube/depman/profile"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/showfrozen"
+	"github.com/vube/depman/timelock"
+	"github.com/vube/depman/util"
+)
+
+// ErrNoGoPath is returned when GOPATH is not set
+var ErrNoGoPath = errors.New("GOPATH is not set")
+
+// ErrNoDepsFile is returned when there is no deps.json at the given path
+var ErrNoDepsFile = errors.New("could not find " + dep.DepsFile)
+
+// Options configures Install, Freeze and Status.
+// The zero value installs one dependency at a time, without retries or a cache, use DefaultOptions for the defaults
+// of the command line
+type Options struct {
+	// Reporter receives the progress of the run, if it is nil nothing is reported
+	Reporter report.Reporter
+
+	// Remove changes to code in dependencies
+	Clean bool
+
+	// Number of dependencies to install in parallel
+	Jobs int
+
+	// Time to wait for another depman process to finish with a dependency, 0 waits forever
+	LockTimeout time.Duration
+
+	// Only install the dependencies in the top level deps.json
+	NoRecurse bool
+
+	// Never access the network, only check out versions already present locally
+	Offline bool
+
+	// Fetch every dependency, and don't write the cache file
+	SkipCache bool
+
+	// Time before a cached dependency is fetched again, unless it has a "cache-ttl" field
+	CacheTTL time.Duration
+
+	// Clone and fetch through a shared local mirror of each repo, kept in MirrorDir
+	Mirror    bool
+	MirrorDir string
+
+	// Maximum time a single VCS command may run, 0 means no limit
+	CommandTimeout time.Duration
+
+	// Number of times to retry network operations which fail with a network error,
+	// waiting RetryWait before the first retry and doubling the wait each time
+	Retries   int
+	RetryWait time.Duration
+
+	// Record the time taken by each operation and report the slowest dependencies and operations at the end of
+	// Install, ProfileTrace is a file to write them to in the Chrome trace event format, it implies Profile
+	Profile      bool
+	ProfileTrace string
+}
+
+// DefaultOptions returns the defaults of the command line, before the configuration (see package config) is applied
+func DefaultOptions() Options {
+	return Options{
+		Jobs:        1,
+		LockTimeout: 5 * time.Minute,
+		CacheTTL:    timelock.DefaultTTL(),
+		MirrorDir:   mirror.DefaultDir(),
+		Retries:     2,
+		RetryWait:   2 * time.Second,
+	}
+}
+
+// Flags registers the command line flags for o which apply to every command on fs, their defaults are the current
+// values of o
+func (o *Options) Flags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Offline, "offline", o.Offline, "Never access the network, only check out versions already present locally (also set by DEPMAN_OFFLINE=1)")
+	fs.DurationVar(&o.CommandTimeout, "command-timeout", o.CommandTimeout, "Maximum time a single VCS command may run (e.g. 5m), 0 means no limit")
+	fs.IntVar(&o.Retries, "retries", o.Retries, "Number of times to retry network operations which fail with a network error")
+	fs.DurationVar(&o.RetryWait, "retry-wait", o.RetryWait, "Time to wait before the first retry, doubled for each following retry")
+}
+
+// InstallFlags registers the command line flags for o which apply to installs on fs, including CacheFlags and MirrorFlags
+func (o *Options) InstallFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Clean, "clean", o.Clean, "Remove changes to code in dependencies")
+	fs.IntVar(&o.Jobs, "jobs", o.Jobs, "Number of dependencies to install in parallel")
+	fs.DurationVar(&o.LockTimeout, "lock-timeout", o.LockTimeout, "Time to wait for another depman process to finish with a dependency (0 waits forever, with a message every minute)")
+	o.CacheFlags(fs)
+	o.MirrorFlags(fs)
+}
+
+// ProfileFlags registers the command line flags for the profile settings of o on fs
+func (o *Options) ProfileFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Profile, "profile", o.Profile, "Display the slowest dependencies and operations at the end of the install")
+	fs.StringVar(&o.ProfileTrace, "profile-trace", o.ProfileTrace, "Write the time taken by each operation to this file as a Chrome trace (implies --profile)")
+}
+
+// CacheFlags registers the command line flags for the cache settings of o on fs
+func (o *Options) CacheFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.SkipCache, "skip-cache", o.SkipCache, "Skip the time based cache for this run only")
+	fs.DurationVar(&o.CacheTTL, "cache-ttl", o.CacheTTL, "Time before a cached dependency is fetched again (also set by DEPMAN_CACHE_TTL)")
+}
+
+// MirrorFlags registers the command line flags for the mirror settings of o on fs
+func (o *Options) MirrorFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Mirror, "mirror", o.Mirror, "Clone and fetch through a shared local mirror of each repo")
+	fs.StringVar(&o.MirrorDir, "mirror-dir", o.MirrorDir, "Directory holding the shared mirrors")
+}
+
+// Configure applies o to the packages of depman, Install, Freeze and Status call it themselves.
+// The command line calls it once for the commands which use the packages directly
+func Configure(o Options) {
+	jobs := o.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	install.SetClean(o.Clean)
+	install.SetJobs(jobs)
+	install.SetLockTimeout(o.LockTimeout)
+	install.Recurse = !o.NoRecurse
+
+	util.SetOffline(o.Offline)
+	util.SetCommandTimeout(o.CommandTimeout)
+	util.SetRetries(o.Retries, o.RetryWait)
+
+	timelock.SetSkip(o.SkipCache)
+	timelock.SetTTL(o.CacheTTL)
+
+	mirror.SetEnabled(o.Mirror)
+	mirror.SetDir(o.MirrorDir)
+
+	profile.SetEnabled(o.Profile || o.ProfileTrace != "")
+}
+
+// DependencyStatus is the state of a dependency in deps.json
+type DependencyStatus struct {
+	Name    string `json:"name"`
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+
+	// whether the dependency has been cloned, and the revision checked out if it has
+	Installed bool   `json:"installed"`
+	Head      string `json:"head,omitempty"`
+
+	// whether the next install will fetch the dependency
+	Stale bool `json:"stale"`
+}
+
+// serializes runs, the packages of depman share their settings
+var running sync.Mutex
+
+// Install installs the dependencies in the deps.json at path (a file or the directory containing it), recursively
+// unless o.NoRecurse is set. Cancelling ctx stops the install as an interrupt would.
+// The summary, which only holds the errors of this run, is returned even if the install failed. The error is the
+// first problem preventing the install (e.g. ErrNoDepsFile), or the result.Errors of the dependencies which failed,
+// or install.ErrOffline
+func Install(ctx context.Context, path string, o Options) (summary report.Summary, err error) {
+	running.Lock()
+	defer running.Unlock()
+
+	start := time.Now()
+	r, mark := begin(ctx, o)
+
+	deps, err := read(path, r)
+	if err == nil {
+		err = cacheError(timelock.Read(r), r)
+	}
+
+	if err == nil {
+		profile.Reset()
+		err = install.Install(deps, r)
+
+		// written even after an interrupt, only dependencies which completed are marked as fresh
+		if werr := cacheError(timelock.Write(r), r); werr != nil && err == nil {
+			err = werr
+		}
+
+		if perr := writeProfile(o, r); perr != nil && err == nil {
+			err = perr
+		}
+	}
+
+	summary = report.Summary{Duration: time.Since(start)}
+	summary.ExitCode, summary.Errors = result.Since(mark)
+	if util.Interrupted() {
+		summary.ExitCode = 130
+	}
+	return
+}
+
+// cacheError registers and reports err, an error reading or writing the cache, to r
+func cacheError(err error, r report.Reporter) error {
+	if err == nil {
+		return nil
+	}
+	e := &result.Error{Op: "cache", Err: err}
+	result.Register(e)
+	r.Error(report.Dependency{}, e)
+	return e
+}
+
+// writeProfile reports the operations recorded by Install to r, and writes them to o.ProfileTrace
+func writeProfile(o Options, r report.Reporter) error {
+	if !profile.Enabled() {
+		return nil
+	}
+	profile.Report(r)
+
+	if o.ProfileTrace == "" {
+		return nil
+	}
+
+	err := profile.WriteTrace(o.ProfileTrace)
+	if err != nil {
+		e := &result.Error{Op: "profile", Err: err}
+		result.Register(e)
+		r.Error(report.Dependency{}, e)
+		return e
+	}
+	r.Info(report.NoIndent, "Trace written to "+o.ProfileTrace)
+	return nil
+}
+
+// Freeze returns the commit checked out for each dependency in the deps.json at path, and recursively for their
+// own dependencies if recursive is true
+func Freeze(ctx context.Context, path string, recursive bool, o Options) (frozen []report.Frozen, err error) {
+	running.Lock()
+	defer running.Unlock()
+
+	r, _ := begin(ctx, o)
+
+	deps, err := read(path, r)
+	if err != nil {
+		return
+	}
+
+	if recursive {
+		return showfrozen.FreezeRecursively(deps, nil, r)
+	}
+	return showfrozen.Freeze(deps, r)
+}
+
+// Status returns the state of each dependency in the deps.json at path, sorted by name.
+// Nothing is fetched, the dependencies are only inspected
+func Status(ctx context.Context, path string, o Options) (status []DependencyStatus, err error) {
+	running.Lock()
+	defer running.Unlock()
+
+	r, _ := begin(ctx, o)
+
+	deps, err := read(path, r)
+	if err != nil {
+		return
+	}
+
+	err = timelock.Read(r)
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for name := range deps.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		d := deps.Map[name]
+		d.Log = util.NewLogger(r, false, 0)
+
+		s := DependencyStatus{Name: name, Repo: d.Repo, Version: d.Version, Path: d.Path(), Stale: timelock.IsStale(d)}
+		if util.Exists(d.Path()) {
+			s.Installed = true
+			s.Head = d.VCS.Revision(d)
+		}
+		status = append(status, s)
+	}
+	return
+}
+
+// begin applies o and derives the context of all commands from ctx. It returns the Reporter to use, and the Mark
+// telling the errors of this run apart from those registered before it, which are kept
+func begin(ctx context.Context, o Options) (r report.Reporter, mark result.Mark) {
+	Configure(o)
+	util.SetContext(ctx)
+	mark = result.Start()
+
+	r = o.Reporter
+	if r == nil {
+		r = report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard)
+	}
+	return
+}
+
+// read reads the deps.json at path, checking GOPATH first. Errors are registered and reported to r
+func read(path string, r report.Reporter) (deps dep.DependencyMap, err error) {
+	path = dep.GetPath(path)
+
+	var e *result.Error
+	switch {
+	case strings.TrimSpace(os.Getenv("GOPATH")) == "":
+		err = ErrNoGoPath
+		e = &result.Error{Kind: result.Config, Op: "read", Err: err}
+	case !util.Exists(path):
+		err = ErrNoDepsFile
+		e = &result.Error{Kind: result.Config, Op: "read", DepsFile: path, Err: err}
+	default:
+		deps, err = dep.Read(path, r)
+		if err == nil {
+			return
+		}
+		e = &result.Error{Kind: result.Config, Op: "read", DepsFile: path, Err: err}
+		err = e
+	}
+
+	result.Register(e)
+	r.Error(report.Dependency{}, e)
+	return
+}
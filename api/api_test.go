@@ -0,0 +1,116 @@
+package api
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "launchpad.net/gocheck"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/install"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/util"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type APISuite struct {
+	dir     string
+	restore func()
+	buf     *bytes.Buffer
+	opts    Options
+}
+
+var _ = Suite(&APISuite{})
+
+func (s *APISuite) SetUpTest(c *C) {
+	colors.Mock()
+	util.Mock(ioutil.Discard)
+
+	s.dir, s.restore = fixture.GoPath(c)
+
+	// a dependency which is never cloned, so nothing touches the network
+	deps := `{"none": {"repo": "github.com/vube/depman-test-none", "version": "master", "type": "git"}}`
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "deps.json"), []byte(deps), 0644), IsNil)
+
+	s.buf = new(bytes.Buffer)
+	s.opts = DefaultOptions()
+	s.opts.Offline = true
+	s.opts.Reporter = report.NewText(log.New(s.buf, "", 0), s.buf)
+}
+
+func (s *APISuite) TearDownTest(c *C) {
+	s.restore()
+	Configure(DefaultOptions())
+	result.Reset()
+}
+
+func (s *APISuite) TestInstall(c *C) {
+	summary, err := Install(context.Background(), s.dir, s.opts)
+	c.Check(err, Equals, install.ErrOffline)
+	c.Check(summary.ExitCode, Equals, int(result.Offline))
+	c.Assert(summary.Errors, HasLen, 1)
+	c.Check(summary.Errors[0].Dep, Equals, "none")
+	c.Check(s.buf.String(), Matches, "(?s).*requires network access: not cloned.*")
+}
+
+func (s *APISuite) TestInstallErrors(c *C) {
+	summary, err := Install(context.Background(), filepath.Join(s.dir, "src"), s.opts)
+	c.Check(err, Equals, ErrNoDepsFile)
+	c.Check(summary.ExitCode, Equals, 2)
+	c.Check(s.buf.String(), Matches, "(?s).*could not find deps.json.*")
+
+	os.Setenv("GOPATH", "")
+	_, err = Install(context.Background(), s.dir, s.opts)
+	c.Check(err, Equals, ErrNoGoPath)
+}
+
+func (s *APISuite) TestInstallKeepsEarlierErrors(c *C) {
+	earlier := &result.Error{Kind: result.Config, Op: "config", Err: errors.New("invalid flag")}
+	result.Register(earlier)
+
+	summary, err := Install(context.Background(), s.dir, s.opts)
+	c.Check(err, Equals, install.ErrOffline)
+	c.Assert(summary.Errors, HasLen, 1)
+	c.Check(summary.Errors[0].Dep, Equals, "none")
+	c.Check(result.All()[0], Equals, earlier)
+}
+
+func (s *APISuite) TestInstallCancelled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.opts.Offline = false
+	summary, err := Install(ctx, s.dir, s.opts)
+	c.Check(err, Equals, util.ErrInterrupted)
+	c.Check(summary.ExitCode, Equals, 130)
+
+	// the next run is not affected
+	s.opts.Offline = true
+	_, err = Install(context.Background(), s.dir, s.opts)
+	c.Check(err, Equals, install.ErrOffline)
+	c.Check(util.Interrupted(), Equals, false)
+}
+
+func (s *APISuite) TestStatus(c *C) {
+	status, err := Status(context.Background(), s.dir, s.opts)
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Check(status[0].Name, Equals, "none")
+	c.Check(status[0].Path, Equals, filepath.Join(s.dir, "src", "github.com/vube/depman-test-none"))
+	c.Check(status[0].Installed, Equals, false)
+	c.Check(status[0].Stale, Equals, true)
+}
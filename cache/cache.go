@@ -0,0 +1,140 @@
+// Package cache provides the cache command, which inspects and edits the time based cache
+package cache
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"errors"
+	"time"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/timelock"
+)
+
+// ErrNoEntry is registered for a repo or nickname which is not in the cache
+var ErrNoEntry = errors.New("no cache entry")
+
+// List reports every entry in the cache to r, with its age and whether it is stale
+func List(deps dep.DependencyMap, r report.Reporter) {
+	r.Info(report.NoIndent, colors.Blue("Cache file: ")+timelock.File())
+
+	entries := timelock.Entries()
+	if len(entries) == 0 {
+		r.Info(0, "(empty)")
+		return
+	}
+
+	ttls := make(map[string]time.Duration)
+	for _, d := range deps.Map {
+		ttls[timelock.Key(d)] = timelock.TTL(d)
+	}
+
+	for _, e := range entries {
+		ttl, ok := ttls[e.Key]
+		if !ok {
+			ttl = timelock.DefaultTTL()
+		}
+		stale := e.Age() > ttl
+
+		state := colors.Blue("fresh")
+		if stale {
+			state = colors.Yellow("stale")
+		}
+
+		lines := []string{colors.Blue(e.Repo) + " " + state + " (fetched " + e.Age().Truncate(time.Second).String() + " ago, ttl " + ttl.String() + ")"}
+		if e.Path != "" {
+			lines = append(lines, "    path:     "+e.Path)
+		}
+		if e.Revision != "" {
+			lines = append(lines, "    revision: "+e.Revision)
+		}
+		if e.Error != "" {
+			lines = append(lines, "    "+colors.Red("error:    "+e.Error))
+		}
+
+		r.Item(report.Item{
+			Event: report.EventCacheEntry,
+			Value: struct {
+				Key string `json:"key"`
+				timelock.Entry
+				Age   float64 `json:"age"`
+				TTL   float64 `json:"ttl"`
+				Stale bool    `json:"stale"`
+			}{e.Key, e, e.Age().Seconds(), ttl.Seconds(), stale},
+			Lines: lines,
+		})
+	}
+}
+
+// Evict removes the cache entries for each target, so they are fetched on the next install, and reports each to r
+func Evict(deps dep.DependencyMap, targets []string, r report.Reporter) {
+	for _, k := range keys(deps, targets, r) {
+		timelock.Evict(k)
+		r.Item(keyItem(report.EventCacheEvicted, "Evicted", k))
+	}
+}
+
+// Touch marks the cache entries for each target as fresh, or every entry if there are no targets, and reports each to r
+func Touch(deps dep.DependencyMap, targets []string, r report.Reporter) {
+	var ks []string
+	if len(targets) == 0 {
+		for _, e := range timelock.Entries() {
+			ks = append(ks, e.Key)
+		}
+	} else {
+		ks = keys(deps, targets, r)
+	}
+
+	for _, k := range ks {
+		timelock.Refresh(k)
+		r.Item(keyItem(report.EventCacheTouched, "Touched", k))
+	}
+}
+
+// keyItem returns the item of type event about the cache entry k, displayed as verb followed by k
+func keyItem(event string, verb string, k string) report.Item {
+	return report.Item{
+		Event: event,
+		Value: struct {
+			Key string `json:"key"`
+		}{k},
+		Lines: []string{verb + " " + k},
+	}
+}
+
+// keys returns the cache keys matching targets, each target is either a nickname from deps.json or a repo.
+// A repo matches every place it is installed. An error is registered and reported to r for targets which match nothing
+func keys(deps dep.DependencyMap, targets []string, r report.Reporter) (ks []string) {
+	entries := timelock.Entries()
+
+	for _, t := range targets {
+		found := false
+
+		if d, ok := deps.Map[t]; ok {
+			k := timelock.Key(d)
+			for _, e := range entries {
+				if e.Key == k {
+					ks = append(ks, k)
+					found = true
+				}
+			}
+		} else {
+			for _, e := range entries {
+				if e.Repo == t {
+					ks = append(ks, e.Key)
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			e := &result.Error{Dep: t, Op: "cache", Err: ErrNoEntry}
+			result.Register(e)
+			r.Error(report.Dependency{}, e)
+		}
+	}
+	return
+}
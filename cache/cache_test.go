@@ -0,0 +1,137 @@
+package cache
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/timelock"
+	"github.com/vube/depman/util"
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func TestCache(t *testing.T) {
+	TestingT(t)
+}
+
+type CacheSuite struct {
+	restore func()
+	dir     string
+	deps    dep.DependencyMap
+	r       report.Reporter
+}
+
+var _ = Suite(&CacheSuite{})
+
+func (s *CacheSuite) SetUpTest(c *C) {
+	colors.Mock()
+	util.Mock(new(bytes.Buffer))
+	s.r = report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard)
+
+	s.dir, s.restore = fixture.GoPath(c)
+	timelock.Read(s.r)
+
+	// the same repo installed twice, once under an alias
+	s.deps = dep.New()
+	s.deps.Map["one"] = &dep.Dependency{Repo: "github.com/vube/one"}
+	s.deps.Map["alias"] = &dep.Dependency{Repo: "github.com/vube/one", Alias: "vube/alias"}
+	s.deps.Map["two"] = &dep.Dependency{Repo: "github.com/vube/two"}
+
+	for _, d := range s.deps.Map {
+		c.Assert(os.MkdirAll(d.Path(), 0755), IsNil)
+		timelock.Touch(d, "abc123")
+	}
+}
+
+func (s *CacheSuite) TearDownTest(c *C) {
+	s.restore()
+}
+
+func (s *CacheSuite) TestEvictByNickname(c *C) {
+	Evict(s.deps, []string{"alias"}, s.r)
+
+	entries := timelock.Entries()
+	c.Assert(entries, HasLen, 2)
+	for _, e := range entries {
+		c.Check(e.Path, Not(Equals), s.deps.Map["alias"].Path())
+	}
+}
+
+func (s *CacheSuite) TestEvictByRepo(c *C) {
+	Evict(s.deps, []string{"github.com/vube/one"}, s.r)
+
+	entries := timelock.Entries()
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Repo, Equals, "github.com/vube/two")
+	c.Check(entries[0].Revision, Equals, "abc123")
+}
+
+func (s *CacheSuite) TestWriteRemovesMissing(c *C) {
+	c.Assert(os.RemoveAll(s.deps.Map["two"].Path()), IsNil)
+	timelock.Write(s.r)
+	timelock.Read(s.r)
+
+	entries := timelock.Entries()
+	c.Assert(entries, HasLen, 2)
+	for _, e := range entries {
+		c.Check(e.Repo, Equals, "github.com/vube/one")
+		c.Check(e.Revision, Equals, "abc123")
+	}
+}
+
+func (s *CacheSuite) TestListJSON(c *C) {
+	var events, buf bytes.Buffer
+	List(s.deps, report.NewJSON(&events, report.NewText(log.New(&buf, "", 0), &buf)))
+
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	c.Assert(lines, HasLen, 3)
+
+	var entry struct {
+		Event    string  `json:"event"`
+		Key      string  `json:"key"`
+		Repo     string  `json:"repo"`
+		Path     string  `json:"path"`
+		Revision string  `json:"revision"`
+		TTL      float64 `json:"ttl"`
+		Stale    bool    `json:"stale"`
+	}
+	c.Assert(json.Unmarshal([]byte(lines[0]), &entry), IsNil)
+	c.Check(entry.Event, Equals, report.EventCacheEntry)
+	c.Check(entry.Repo, Equals, "github.com/vube/one")
+	c.Check(entry.Key, Equals, timelock.Key(s.deps.Map["one"]))
+	c.Check(entry.Path, Equals, s.deps.Map["one"].Path())
+	c.Check(entry.Revision, Equals, "abc123")
+	c.Check(entry.TTL, Equals, timelock.DefaultTTL().Seconds())
+	c.Check(entry.Stale, Equals, false)
+
+	// only the cache file is displayed
+	c.Check(buf.String(), Matches, "Cache file: .*\n")
+}
+
+func (s *CacheSuite) TestEvictJSON(c *C) {
+	var events, buf bytes.Buffer
+	r := report.NewJSON(&events, report.NewText(log.New(&buf, "", 0), &buf))
+	defer result.Reset()
+
+	Evict(s.deps, []string{"two", "missing"}, r)
+	Touch(s.deps, []string{"one"}, r)
+
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	c.Assert(lines, HasLen, 3)
+	c.Check(lines[0], Equals, `{"event":"error","name":"missing","stale":false,"op":"cache","error":"no cache entry"}`)
+	c.Check(lines[1], Equals, `{"event":"cache-evicted","key":"`+timelock.Key(s.deps.Map["two"])+`"}`)
+	c.Check(lines[2], Equals, `{"event":"cache-touched","key":"`+timelock.Key(s.deps.Map["one"])+`"}`)
+	c.Check(result.ExitCode(), Equals, 1)
+}
@@ -1,5 +1,6 @@
 // Package colors provides functions to optionally wrap strings with ASCII colors codes.
-// The display of colors is disabled by the --no-colors flag
+// The display of colors is disabled by the --no-colors flag, which is registered by Flags, and by Detect when the
+// output is not a terminal
 package colors
 
 // Copyright 2013-2014 Vubeology, Inc.
@@ -7,15 +8,51 @@ package colors
 import (
 	"flag"
 	"fmt"
+	"os"
 )
 
 var (
 	// Disable colors
 	noColors bool
+
+	// why Detect disabled colors, see Detected
+	detected string
 )
 
-func init() {
-	flag.BoolVar(&noColors, "no-colors", false, "Disable colors")
+// Flags registers --no-colors on fs
+func Flags(fs *flag.FlagSet) {
+	fs.BoolVar(&noColors, "no-colors", false, "Disable colors")
+}
+
+// SetEnabled enables or disables colors
+func SetEnabled(enabled bool) {
+	noColors = !enabled
+}
+
+// Detect disables colors if the NO_COLOR environment variable is set, or if terminal is false (the output is not a
+// terminal) unless FORCE_COLOR is set. FORCE_COLOR=0 also disables colors. Colors disabled by --no-colors stay disabled
+func Detect(terminal bool) {
+	if noColors {
+		return
+	}
+
+	force := os.Getenv("FORCE_COLOR")
+	switch {
+	case os.Getenv("NO_COLOR") != "":
+		detected = "NO_COLOR"
+	case force == "0" || force == "false":
+		detected = "FORCE_COLOR"
+	case force != "":
+	case !terminal:
+		detected = "not a terminal"
+	}
+	noColors = detected != ""
+}
+
+// Detected returns why Detect disabled colors: the environment variable which disabled them or "not a terminal",
+// or an empty string if it did not
+func Detected() string {
+	return detected
 }
 
 // Yellow returns s wrapped in Yellow ASCII Color Codes
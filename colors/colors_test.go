@@ -3,6 +3,7 @@ package colors
 // Copyright 2013-2014 Vubeology, Inc.
 
 import (
+	"os"
 	"testing"
 	. "launchpad.net/gocheck"
 )
@@ -59,3 +60,48 @@ func (s *ColorsSuite) TestYellow(c *C) {
 	y = Yellow(str)
 	c.Check(y, Equals, str)
 }
+
+func (s *ColorsSuite) TestDetect(c *C) {
+	env := map[string]string{"NO_COLOR": os.Getenv("NO_COLOR"), "FORCE_COLOR": os.Getenv("FORCE_COLOR")}
+	defer func() {
+		for k, v := range env {
+			os.Setenv(k, v)
+		}
+	}()
+
+	tests := []struct {
+		noColor, forceColor string
+		terminal            bool
+		enabled             bool
+	}{
+		{"", "", true, true},
+		{"", "", false, false},
+		{"1", "", true, false},
+		{"1", "1", false, false},
+		{"", "1", false, true},
+		{"", "0", true, false},
+	}
+	for _, t := range tests {
+		os.Setenv("NO_COLOR", t.noColor)
+		os.Setenv("FORCE_COLOR", t.forceColor)
+		noColors = false
+		detected = ""
+		Detect(t.terminal)
+		c.Check(noColors, Equals, !t.enabled, Commentf("%+v", t))
+	}
+	c.Check(Detected(), Equals, "FORCE_COLOR")
+
+	os.Setenv("FORCE_COLOR", "")
+	noColors = false
+	detected = ""
+	Detect(false)
+	c.Check(Detected(), Equals, "not a terminal")
+
+	// --no-colors is never overridden
+	os.Setenv("FORCE_COLOR", "1")
+	noColors = true
+	detected = ""
+	Detect(true)
+	c.Check(noColors, Equals, true)
+	c.Check(Detected(), Equals, "")
+}
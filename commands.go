@@ -0,0 +1,418 @@
+package main
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/vube/depman/add"
+	"github.com/vube/depman/api"
+	"github.com/vube/depman/cache"
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/config"
+	"github.com/vube/depman/create"
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/doctor"
+	"github.com/vube/depman/mirror"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/update"
+	"github.com/vube/depman/upgrade"
+	"github.com/vube/depman/util"
+)
+
+// How a command uses deps.json
+const (
+	depsNone     = iota // not read
+	depsRequired        // must exist
+	depsOptional        // read if it exists
+)
+
+// command is a sub command of depman, such as install
+type command struct {
+	name    string
+	aliases []string
+
+	// the arguments, for help, e.g. "[nickname] [branch]"
+	args string
+
+	// one line description
+	summary string
+
+	// the minimum number of arguments
+	minArgs int
+
+	deps int
+
+	// registers the flags of the command on fs, they are bound to o or to variables of the command
+	flags func(o *api.Options, fs *flag.FlagSet)
+
+	// runs the command, it is nil for commands which only have sub commands
+	run func(s *session)
+
+	// returns the candidates for argument n when completing the command line, s.args holds the arguments before it
+	complete func(s *session, n int) []string
+
+	// not listed by help
+	hidden bool
+
+	// runs without the version, the deprecation notice, the cache or the upgrade check, so the shell can read its output
+	bare bool
+
+	// runs without checking GOPATH or reading the cache, so it can diagnose them
+	standalone bool
+
+	// runs through package api, which reads deps.json and the cache itself and returns the summary of the run
+	api bool
+
+	// sub commands, selected by the first argument
+	subs []*command
+}
+
+// session is the state of a single run of depman
+type session struct {
+	path string
+	deps dep.DependencyMap
+	opts api.Options
+	r    report.Reporter
+	args []string
+
+	// the configuration files and environment variables
+	cfg *config.Config
+
+	// the summary returned by package api, for the commands which run through it
+	summary *report.Summary
+}
+
+// set by the flags of show-frozen
+var frozenRecursive bool
+
+// commands is the registry of commands, in the order they are displayed by help.
+// It is set up in init because help refers to it
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "init",
+			aliases: []string{"create"},
+			summary: "Create an empty deps.json",
+			run: func(s *session) {
+				create.Create(s.path, s.r)
+			},
+		},
+		{
+			name:    "add",
+			args:    "[nickname]",
+			summary: "Add a dependency (interactive)",
+			minArgs: 1,
+			deps:    depsRequired,
+			flags:   (*api.Options).InstallFlags,
+			run: func(s *session) {
+				add.Add(s.deps, s.args[0], s.r)
+			},
+		},
+		{
+			name:    "install",
+			summary: "Install all the dependencies listed in deps.json (default)",
+			deps:    depsRequired,
+			flags: func(o *api.Options, fs *flag.FlagSet) {
+				o.InstallFlags(fs)
+				o.ProfileFlags(fs)
+			},
+			api: true,
+			run: func(s *session) {
+				// errors have been registered and reported, the summary holds them
+				summary, _ := api.Install(util.Context(), s.path, s.opts)
+				s.summary = &summary
+			},
+		},
+		{
+			name:    "update",
+			args:    "[nickname] [branch]",
+			summary: "Update [nickname] to use the latest commit in [branch]",
+			minArgs: 2,
+			deps:    depsRequired,
+			flags:   (*api.Options).InstallFlags,
+			run: func(s *session) {
+				update.Update(s.deps, s.args[0], s.args[1], s.r)
+			},
+			complete: func(s *session, n int) []string {
+				if n == 0 {
+					return nicknames(s)
+				}
+				return versions(s, s.args[0])
+			},
+		},
+		{
+			name:    "self-upgrade",
+			summary: "Upgrade depman to the latest version on the master branch",
+			run: func(s *session) {
+				upgrade.Self(VERSION)
+			},
+		},
+		{
+			name:  "cache",
+			deps:  depsOptional,
+			flags: (*api.Options).CacheFlags,
+			subs: []*command{
+				{
+					name:    "list",
+					summary: "Show each entry in the cache, with its age and whether it is stale",
+					run: func(s *session) {
+						cache.List(s.deps, s.r)
+					},
+				},
+				{
+					name:    "evict",
+					args:    "[repo|nickname]",
+					summary: "Remove entries from the cache, so they are fetched on the next install",
+					minArgs: 1,
+					run: func(s *session) {
+						cache.Evict(s.deps, s.args, s.r)
+					},
+					complete: func(s *session, n int) []string {
+						return nicknames(s)
+					},
+				},
+				{
+					name:    "touch",
+					args:    "[repo|nickname]",
+					summary: "Mark entries in the cache as fresh (all entries if none are given)",
+					run: func(s *session) {
+						cache.Touch(s.deps, s.args, s.r)
+					},
+					complete: func(s *session, n int) []string {
+						return nicknames(s)
+					},
+				},
+			},
+		},
+		{
+			name:  "mirror",
+			flags: (*api.Options).MirrorFlags,
+			subs: []*command{
+				{
+					name:    "gc",
+					args:    "[days]",
+					summary: "Remove shared mirrors which have not been used in [days] days (default 30)",
+					run: func(s *session) {
+						days := 30
+						if len(s.args) > 0 {
+							var err error
+							days, err = strconv.Atoi(s.args[0])
+							if err != nil || days < 0 {
+								util.Abort(result.Config, "Invalid number of days: "+s.args[0])
+							}
+						}
+						mirror.GC(days, s.r)
+					},
+				},
+			},
+		},
+		{
+			name:       "doctor",
+			summary:    "Check GOPATH, the version control systems, the cache and access to the remotes",
+			deps:       depsOptional,
+			standalone: true,
+			run: func(s *session) {
+				doctor.Run(s.deps, s.r)
+			},
+		},
+		{
+			name: "config",
+			subs: []*command{
+				{
+					name:    "show",
+					summary: "Show the value of each option, and where it was set",
+					run:     showConfig,
+				},
+			},
+		},
+		{
+			name:    "help",
+			args:    "[command]",
+			summary: "Display this help, or the help of [command]",
+			run: func(s *session) {
+				if len(s.args) == 0 {
+					Help()
+					return
+				}
+
+				c := lookup(s.args[0])
+				if c == nil {
+					unknown(s.args[0])
+					return
+				}
+				c.help(log.Writer())
+			},
+			complete: func(s *session, n int) []string {
+				if n == 0 {
+					return names(commands)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "show-frozen",
+			summary: "Show dependencies as resolved to commit IDs",
+			deps:    depsRequired,
+			flags: func(o *api.Options, fs *flag.FlagSet) {
+				fs.BoolVar(&frozenRecursive, "recursive", false, "descend recursively (depth-first) into dependencies")
+			},
+			run: func(s *session) {
+				frozen, err := api.Freeze(util.Context(), s.path, frozenRecursive, s.opts)
+				if err == nil {
+					s.r.Frozen(frozen)
+				}
+			},
+		},
+		{
+			name:    "completion",
+			args:    "[shell]",
+			summary: "Write the completion script for [shell] (bash, zsh or fish) to stdout",
+			minArgs: 1,
+			bare:    true,
+			run: func(s *session) {
+				script, ok := completionScripts[s.args[0]]
+				if !ok {
+					util.Abort(result.Config, "Unknown shell '"+s.args[0]+"', must be bash, zsh or fish")
+				}
+				fmt.Print(script)
+			},
+			complete: func(s *session, n int) []string {
+				if n == 0 {
+					return []string{"bash", "fish", "zsh"}
+				}
+				return nil
+			},
+		},
+		{
+			name:   "__complete",
+			args:   "-- [words]",
+			hidden: true,
+			bare:   true,
+			run: func(s *session) {
+				for _, candidate := range complete(s.args) {
+					fmt.Println(candidate)
+				}
+			},
+		},
+	}
+}
+
+// lookup returns the command called name (or one of its aliases), nil if there is none
+func lookup(name string) *command {
+	return find(commands, name)
+}
+
+// find returns the command in list called name (or one of its aliases), nil if there is none
+func find(list []*command, name string) *command {
+	name = strings.ToLower(name)
+	for _, c := range list {
+		if c.name == name {
+			return c
+		}
+		for _, a := range c.aliases {
+			if a == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// unknown registers and displays an unknown command
+func unknown(name string) {
+	result.Register(&result.Error{Kind: result.Config, Op: name, Err: errUnknownCommand})
+	log.Println(colors.Red("Unknown Command: " + name))
+	Help()
+}
+
+// flagSet returns the flags of c bound to o, errors are written to w
+func (c *command) flagSet(o *api.Options, w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	if c.flags != nil {
+		c.flags(o, fs)
+	}
+	fs.Usage = func() {
+		c.help(w)
+	}
+	return fs
+}
+
+// usage returns the command line of c, e.g. "update [nickname] [branch]"
+func (c *command) usage() string {
+	u := c.name
+	if c.args != "" {
+		u += " " + c.args
+	}
+	return u
+}
+
+// lines returns the lines describing c and its sub commands in the list of commands
+func (c *command) lines() (lines []string) {
+	if c.hidden {
+		return nil
+	}
+	if len(c.subs) == 0 {
+		return []string{fmt.Sprintf("   %-28s: %s", title(c.usage()), c.summary)}
+	}
+	for _, sub := range c.subs {
+		lines = append(lines, fmt.Sprintf("   %-28s: %s", title(c.name)+" "+sub.usage(), sub.summary))
+	}
+	return
+}
+
+// help writes the help of c to w
+func (c *command) help(w io.Writer) {
+	fmt.Fprintln(w, "")
+	if len(c.subs) == 0 {
+		fmt.Fprintln(w, "Usage: depman [options] "+c.usage())
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, c.summary)
+	} else {
+		fmt.Fprintln(w, "Usage: depman [options] "+c.name+" [sub command]")
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "Sub commands:")
+		for _, l := range c.lines() {
+			fmt.Fprintln(w, l)
+		}
+	}
+
+	if len(c.aliases) > 0 {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "Aliases: "+strings.Join(c.aliases, ", "))
+	}
+
+	o := api.DefaultOptions()
+	fs := c.flagSet(&o, w)
+
+	count := 0
+	fs.VisitAll(func(*flag.Flag) { count++ })
+	if count > 0 {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "Options:")
+		fs.PrintDefaults()
+	}
+
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run 'depman help' for the global options")
+}
+
+// title capitalizes each word of a command name, e.g. "show-frozen" is "Show-Frozen"
+func title(s string) string {
+	b := []byte(s)
+	for i := range b {
+		if (i == 0 || b[i-1] == ' ' || b[i-1] == '-') && b[i] >= 'a' && b[i] <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,106 @@
+package main
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	. "launchpad.net/gocheck"
+
+	"github.com/vube/depman/api"
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/util"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CommandSuite struct {
+	buf *bytes.Buffer
+}
+
+var _ = Suite(&CommandSuite{})
+
+func (s *CommandSuite) SetUpTest(c *C) {
+	colors.Mock()
+	s.buf = new(bytes.Buffer)
+	log.SetFlags(0)
+	log.SetOutput(s.buf)
+}
+
+func (s *CommandSuite) TearDownTest(c *C) {
+	log.SetOutput(os.Stderr)
+	result.Reset()
+}
+
+func (s *CommandSuite) TestLookup(c *C) {
+	c.Check(lookup("install").name, Equals, "install")
+	c.Check(lookup("Show-Frozen").name, Equals, "show-frozen")
+	c.Check(lookup("create").name, Equals, "init")
+	c.Check(lookup("nope"), IsNil)
+}
+
+func (s *CommandSuite) TestParseCommand(c *C) {
+	o := api.DefaultOptions()
+	cmd, args := parseCommand(lookup("update"), &o, nil, []string{"update", "--clean", "--jobs=4", "foo", "master"})
+	c.Check(cmd.name, Equals, "update")
+	c.Check(args, DeepEquals, []string{"foo", "master"})
+	c.Check(o.Clean, Equals, true)
+	c.Check(o.Jobs, Equals, 4)
+	c.Check(result.ShouldExitWithError(), Equals, false)
+
+	// sub commands are selected by the first argument
+	cmd, args = parseCommand(lookup("cache"), &o, nil, []string{"cache", "evict", "foo"})
+	c.Check(cmd.name, Equals, "evict")
+	c.Check(args, DeepEquals, []string{"foo"})
+}
+
+func (s *CommandSuite) TestParseCommandErrors(c *C) {
+	o := api.DefaultOptions()
+
+	// the flags of install are not accepted by init
+	cmd, _ := parseCommand(lookup("init"), &o, nil, []string{"init", "--clean"})
+	c.Check(cmd, IsNil)
+	c.Check(o.Clean, Equals, false)
+	c.Check(result.ExitCode(), Equals, int(result.Config))
+	c.Check(s.buf.String(), Matches, "(?s)flag provided but not defined: -clean\n.*Usage: depman \\[options\\] init\n.*")
+
+	result.Reset()
+	cmd, _ = parseCommand(lookup("update"), &o, nil, []string{"update", "foo"})
+	c.Check(cmd, IsNil)
+	c.Check(result.All()[0].Err, Equals, errMissingArguments)
+
+	result.Reset()
+	cmd, _ = parseCommand(lookup("mirror"), &o, nil, []string{"mirror", "nope"})
+	c.Check(cmd, IsNil)
+	c.Check(result.All()[0].Err, Equals, errMissingSubCommand)
+
+	// -h displays the help and exits successfully
+	code := -1
+	util.OsExit = func(c int) { code = c }
+	defer func() { util.OsExit = os.Exit }()
+
+	result.Reset()
+	s.buf.Reset()
+	cmd, _ = parseCommand(lookup("install"), &o, nil, []string{"install", "-h"})
+	c.Check(cmd, IsNil)
+	c.Check(code, Equals, 0)
+	c.Check(result.ExitCode(), Equals, 0)
+	c.Check(s.buf.String(), Matches, "(?s).*Usage: depman \\[options\\] install\n.*")
+}
+
+func (s *CommandSuite) TestHelp(c *C) {
+	Help()
+	c.Check(s.buf.String(), Matches, "(?s).*\n   Update \\[nickname\\] \\[branch\\]  : Update \\[nickname\\] to use the latest commit in \\[branch\\]\n.*")
+	c.Check(s.buf.String(), Matches, "(?s).*\n   Cache evict \\[repo\\|nickname\\] : Remove entries from the cache.*")
+
+	s.buf.Reset()
+	lookup("show-frozen").help(s.buf)
+	c.Check(s.buf.String(), Matches, "(?s).*Usage: depman \\[options\\] show-frozen\n.*-recursive\n.*")
+}
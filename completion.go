@@ -0,0 +1,193 @@
+package main
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/vube/depman/api"
+	"github.com/vube/depman/config"
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/util"
+)
+
+// completionScripts are the scripts written by 'depman completion [shell]', each passes the words of the command line
+// up to the cursor to 'depman __complete' and offers the lines it writes
+var completionScripts = map[string]string{
+	"bash": `# bash completion for depman, load with: source <(depman completion bash)
+_depman() {
+	local IFS=$'\n'
+	COMPREPLY=($(depman __complete -- "${COMP_WORDS[@]:1:$COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _depman depman
+`,
+	"zsh": `#compdef depman
+# zsh completion for depman, load with: source <(depman completion zsh)
+_depman() {
+	local -a candidates
+	candidates=(${(f)"$(depman __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	compadd -a candidates
+}
+compdef _depman depman
+`,
+	"fish": `# fish completion for depman, load with: depman completion fish | source
+complete -c depman -f -a '(depman __complete -- (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`,
+}
+
+// complete returns the candidates for the last of words, the command line after 'depman' up to the cursor
+func complete(words []string) (candidates []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+
+	s := &session{path: "."}
+	s.opts = api.DefaultOptions()
+
+	// the global flags, the command and its flags and arguments
+	args := skipFlags(flag.CommandLine, words[:len(words)-1], s)
+
+	var c *command
+	if len(args) > 0 {
+		c = lookup(args[0])
+		if c == nil {
+			// plugins complete their own arguments
+			return
+		}
+	}
+
+	switch {
+	case c == nil && strings.HasPrefix(cur, "-"):
+		candidates = flagNames(flag.CommandLine)
+	case c == nil:
+		candidates = append(names(commands), plugins()...)
+	case strings.HasPrefix(cur, "-"):
+		candidates = flagNames(c.flagSet(&s.opts, ioutil.Discard))
+	default:
+		args = skipFlags(c.flagSet(&s.opts, ioutil.Discard), args[1:], s)
+		if len(c.subs) > 0 {
+			if len(args) == 0 {
+				candidates = names(c.subs)
+				break
+			}
+			c = find(c.subs, args[0])
+			if c == nil {
+				return
+			}
+			args = args[1:]
+		}
+
+		if c.complete != nil {
+			s.args = args
+			candidates = c.complete(s, len(args))
+		}
+	}
+
+	return matching(candidates, cur)
+}
+
+// matching returns the candidates starting with prefix, sorted
+func matching(candidates []string, prefix string) (matches []string) {
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+	return
+}
+
+// skipFlags returns words without the flags defined in fs and their values, up to the first word which isn't a flag.
+// The path of deps.json is taken from --path
+func skipFlags(fs *flag.FlagSet, words []string, s *session) []string {
+	for len(words) > 0 && strings.HasPrefix(words[0], "-") && words[0] != "-" {
+		if words[0] == "--" {
+			return words[1:]
+		}
+
+		name := strings.TrimLeft(words[0], "-")
+		value := ""
+		hasValue := false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		words = words[1:]
+
+		f := fs.Lookup(name)
+		if f == nil || hasValue || config.IsBoolFlag(f) {
+			if name == "path" && hasValue {
+				s.path = value
+			}
+			continue
+		}
+
+		// the value is the next word
+		if len(words) > 0 {
+			if name == "path" {
+				s.path = words[0]
+			}
+			words = words[1:]
+		}
+	}
+	return words
+}
+
+// flagNames returns the flags of fs, e.g. "--clean"
+func flagNames(fs *flag.FlagSet) (names []string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, "--"+f.Name)
+	})
+	return
+}
+
+// names returns the names of the commands in list which are listed by help
+func names(list []*command) (names []string) {
+	for _, c := range list {
+		if !c.hidden {
+			names = append(names, c.name)
+		}
+	}
+	return
+}
+
+// readQuietly reads deps.json for completion, an invalid or missing file has no dependencies
+func readQuietly(s *session) {
+	if s.deps.Map != nil {
+		return
+	}
+
+	s.deps = dep.New()
+	if path := dep.GetPath(s.path); util.Exists(path) {
+		if deps, err := dep.Read(path, report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard)); err == nil {
+			s.deps = deps
+		}
+	}
+}
+
+// nicknames returns the nicknames of the dependencies in deps.json
+func nicknames(s *session) (names []string) {
+	readQuietly(s)
+	for name := range s.deps.Map {
+		names = append(names, name)
+	}
+	return
+}
+
+// versions returns the branches and tags of the dependency called name, if it is installed
+func versions(s *session, name string) []string {
+	readQuietly(s)
+	d, ok := s.deps.Map[name]
+	if !ok || !util.Exists(d.Path()) {
+		return nil
+	}
+
+	d.Log = util.NewLogger(report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard), false, 0)
+	names, _ := d.VCS.Versions(d)
+	return names
+}
@@ -0,0 +1,99 @@
+package main
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/vube/depman/fixture"
+	. "launchpad.net/gocheck"
+)
+
+type CompletionSuite struct {
+	dir     string
+	wd      string
+	path    string
+	restore func()
+}
+
+var _ = Suite(&CompletionSuite{})
+
+func (s *CompletionSuite) SetUpTest(c *C) {
+	s.dir, s.restore = fixture.GoPath(c)
+
+	var err error
+	s.wd, err = os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(s.dir), IsNil)
+
+	// no plugins
+	git, err := exec.LookPath("git")
+	c.Assert(err, IsNil)
+	s.path = os.Getenv("PATH")
+	os.Setenv("PATH", filepath.Dir(git))
+
+	deps := `{
+		"foo": {"repo": "example.com/foo", "version": "master", "type": "git"},
+		"fizz": {"repo": "example.com/fizz", "version": "master", "type": "git"},
+		"bar": {"repo": "example.com/bar", "version": "master", "type": "git"}
+	}`
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "deps.json"), []byte(deps), 0644), IsNil)
+}
+
+func (s *CompletionSuite) TearDownTest(c *C) {
+	os.Chdir(s.wd)
+	os.Setenv("PATH", s.path)
+	s.restore()
+}
+
+func (s *CompletionSuite) TestCommands(c *C) {
+	c.Check(complete([]string{"s"}), DeepEquals, []string{"self-upgrade", "show-frozen"})
+	c.Check(complete([]string{"cache", ""}), DeepEquals, []string{"evict", "list", "touch"})
+	c.Check(complete([]string{"help", "com"}), DeepEquals, []string{"completion"})
+	c.Check(complete([]string{"update", "--c"}), DeepEquals, []string{"--cache-ttl", "--clean"})
+
+	// hidden commands are neither completed nor listed
+	c.Check(complete([]string{"__"}), IsNil)
+	c.Check(lookup("__complete").lines(), IsNil)
+}
+
+func (s *CompletionSuite) TestNicknames(c *C) {
+	c.Check(complete([]string{"update", ""}), DeepEquals, []string{"bar", "fizz", "foo"})
+	c.Check(complete([]string{"update", "--jobs", "2", "f"}), DeepEquals, []string{"fizz", "foo"})
+	c.Check(complete([]string{"cache", "evict", "bar", "f"}), DeepEquals, []string{"fizz", "foo"})
+
+	// deps.json is looked up in --path
+	c.Assert(os.Chdir("/"), IsNil)
+	c.Check(complete([]string{"update", "b"}), IsNil)
+	c.Check(complete([]string{"--path=" + s.dir, "update", "b"}), DeepEquals, []string{"bar"})
+}
+
+func (s *CompletionSuite) TestVersions(c *C) {
+	// not installed
+	c.Check(complete([]string{"update", "foo", ""}), IsNil)
+
+	repo := filepath.Join(s.dir, "src", "example.com", "foo")
+	c.Assert(os.MkdirAll(repo, 0755), IsNil)
+	fixture.Git(c, repo, "init", "-q", "-b", "master")
+	fixture.Git(c, repo, "commit", "-q", "--allow-empty", "-m", "first")
+	fixture.Git(c, repo, "branch", "develop")
+	fixture.Git(c, repo, "tag", "v1.0")
+
+	c.Check(complete([]string{"update", "foo", ""}), DeepEquals, []string{"develop", "master", "v1.0"})
+	c.Check(complete([]string{"update", "foo", "v"}), DeepEquals, []string{"v1.0"})
+}
+
+func (s *CompletionSuite) TestSkipFlags(c *C) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("verbose", false, "")
+	fs.String("path", ".", "")
+
+	sess := new(session)
+	c.Check(skipFlags(fs, []string{"--verbose", "--path", "x", "install", "--clean"}, sess), DeepEquals, []string{"install", "--clean"})
+	c.Check(sess.path, Equals, "x")
+	c.Check(skipFlags(fs, []string{"-verbose=true", "--", "-x"}, sess), DeepEquals, []string{"-x"})
+}
@@ -0,0 +1,198 @@
+// Package config reads the defaults of the options of depman from the user's ~/.depmanrc, the .depman.json of the
+// project and DEPMAN_* environment variables.
+// Both files hold a JSON object from option names to values, e.g. {"verbose": true, "cache-ttl": "30m"}, and
+// DEPMAN_SKIP_CACHE=1 sets the option skip-cache. Later sources override earlier ones, and options given on the
+// command line override them all
+package config
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Files and environment variables holding settings
+const (
+	UserFile    = ".depmanrc"
+	ProjectFile = ".depman.json"
+	EnvPrefix   = "DEPMAN_"
+)
+
+// Sources of settings which are not files or environment variables
+const (
+	SourceDefault     = "default"
+	SourceCommandLine = "command line"
+)
+
+// Ignored are the options which are actions rather than defaults, they are only read from the command line
+var Ignored = map[string]bool{
+	"help":        true,
+	"version":     true,
+	"clear-cache": true,
+	"path":        true,
+}
+
+// Setting is the value of an option and where it was set
+type Setting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// a file, an environment variable, SourceDefault or SourceCommandLine
+	Source string `json:"source"`
+}
+
+// Config holds the settings read from the files and the environment, by option name
+type Config struct {
+	settings map[string]Setting
+
+	// settings from files for options which don't exist, environment variables are not checked
+	unknown []Setting
+}
+
+// UserPath returns the path of ~/.depmanrc
+func UserPath() string {
+	return filepath.Join(os.Getenv("HOME"), UserFile)
+}
+
+// Load reads ~/.depmanrc, the .depman.json in projectDir and the environment, in that order.
+// known returns true for the names of the options, files setting any other option are reported by Unknown
+func Load(projectDir string, known func(name string) bool) (c *Config, err error) {
+	c = &Config{settings: make(map[string]Setting)}
+
+	for _, file := range []string{UserPath(), filepath.Join(projectDir, ProjectFile)} {
+		err = c.readFile(file, known)
+		if err != nil {
+			return
+		}
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, EnvPrefix) {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		name := strings.ToLower(strings.Replace(strings.TrimPrefix(parts[0], EnvPrefix), "_", "-", -1))
+
+		// other variables, e.g. those passed to plugins, are not options
+		if known(name) && !Ignored[name] {
+			c.settings[name] = Setting{Name: name, Value: parts[1], Source: parts[0]}
+		}
+	}
+	return
+}
+
+// readFile reads the settings in file if it exists
+func (c *Config) readFile(file string, known func(name string) bool) error {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+
+	for name, v := range values {
+		s := Setting{Name: name, Value: fmt.Sprint(v), Source: file}
+		if !known(name) || Ignored[name] {
+			c.unknown = append(c.unknown, s)
+			continue
+		}
+
+		switch v.(type) {
+		case string, bool, float64:
+		default:
+			return fmt.Errorf("%s: the value of '%s' must be a string, a number or a boolean", file, name)
+		}
+		c.settings[name] = s
+	}
+	return nil
+}
+
+// Unknown returns the settings in files for options which don't exist, sorted by name
+func (c *Config) Unknown() []Setting {
+	sort.Sort(byName(c.unknown))
+	return c.unknown
+}
+
+// Get returns the setting for the option name, ok is false if it is not set
+func (c *Config) Get(name string) (s Setting, ok bool) {
+	if c == nil {
+		return
+	}
+	s, ok = c.settings[name]
+	return
+}
+
+// Apply sets the flags of fs which have not been set on the command line to their configured values.
+// It is called before fs is parsed, or after for flag.CommandLine, which is parsed before the project is known
+func (c *Config) Apply(fs *flag.FlagSet) error {
+	if c == nil {
+		return nil
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		s, ok := c.settings[f.Name]
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+
+		value := s.Value
+		if IsBoolFlag(f) {
+			value = boolValue(value)
+		}
+
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("%s: invalid value '%s' for %s: %s", s.Source, s.Value, f.Name, e)
+		}
+	})
+	return err
+}
+
+// IsBoolFlag returns true if f doesn't take a value, e.g. --verbose
+func IsBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// boolValue translates the words accepted for booleans in addition to those of strconv.ParseBool
+func boolValue(v string) string {
+	switch strings.ToLower(v) {
+	case "yes", "on":
+		return "true"
+	case "no", "off", "":
+		return "false"
+	}
+	return v
+}
+
+// byName sorts settings by name
+type byName []Setting
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
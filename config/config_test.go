@@ -0,0 +1,131 @@
+package config
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ConfigSuite struct {
+	dir  string
+	home string
+	fs   *flag.FlagSet
+
+	verbose bool
+	jobs    int
+	ttl     time.Duration
+}
+
+var _ = Suite(&ConfigSuite{})
+
+func (s *ConfigSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "DepmanUnitTest")
+	c.Assert(err, IsNil)
+
+	s.home = os.Getenv("HOME")
+	os.Setenv("HOME", s.dir)
+
+	s.fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	s.fs.BoolVar(&s.verbose, "verbose", false, "")
+	s.fs.IntVar(&s.jobs, "jobs", 1, "")
+	s.fs.DurationVar(&s.ttl, "cache-ttl", time.Hour, "")
+	s.fs.Bool("version", false, "")
+}
+
+func (s *ConfigSuite) TearDownTest(c *C) {
+	os.Setenv("HOME", s.home)
+	os.Unsetenv("DEPMAN_JOBS")
+	os.Unsetenv("DEPMAN_VERBOSE")
+	os.Unsetenv("DEPMAN_VERSION")
+	os.RemoveAll(s.dir)
+}
+
+func (s *ConfigSuite) known(name string) bool {
+	return s.fs.Lookup(name) != nil
+}
+
+func (s *ConfigSuite) write(c *C, file string, data string) {
+	c.Assert(ioutil.WriteFile(file, []byte(data), 0644), IsNil)
+}
+
+func (s *ConfigSuite) TestPrecedence(c *C) {
+	project := filepath.Join(s.dir, "project")
+	c.Assert(os.Mkdir(project, 0755), IsNil)
+
+	s.write(c, filepath.Join(s.dir, UserFile), `{"verbose": true, "jobs": 2, "cache-ttl": "30m"}`)
+	s.write(c, filepath.Join(project, ProjectFile), `{"jobs": 3, "cache-ttl": "2h"}`)
+	os.Setenv("DEPMAN_JOBS", "4")
+
+	// not an option which can be configured
+	os.Setenv("DEPMAN_VERSION", "1")
+
+	cfg, err := Load(project, s.known)
+	c.Assert(err, IsNil)
+	c.Check(cfg.Unknown(), HasLen, 0)
+
+	// the command line wins
+	c.Assert(s.fs.Parse([]string{"--cache-ttl=5m"}), IsNil)
+	c.Assert(cfg.Apply(s.fs), IsNil)
+
+	c.Check(s.verbose, Equals, true)
+	c.Check(s.jobs, Equals, 4)
+	c.Check(s.ttl, Equals, 5*time.Minute)
+	c.Check(s.fs.Lookup("version").Value.String(), Equals, "false")
+
+	v, ok := cfg.Get("verbose")
+	c.Check(ok, Equals, true)
+	c.Check(v.Source, Equals, filepath.Join(s.dir, UserFile))
+
+	v, _ = cfg.Get("jobs")
+	c.Check(v, Equals, Setting{Name: "jobs", Value: "4", Source: "DEPMAN_JOBS"})
+
+	v, _ = cfg.Get("cache-ttl")
+	c.Check(v.Source, Equals, filepath.Join(project, ProjectFile))
+}
+
+func (s *ConfigSuite) TestBoolWords(c *C) {
+	os.Setenv("DEPMAN_VERBOSE", "yes")
+
+	cfg, err := Load(s.dir, s.known)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Apply(s.fs), IsNil)
+	c.Check(s.verbose, Equals, true)
+}
+
+func (s *ConfigSuite) TestErrors(c *C) {
+	file := filepath.Join(s.dir, ProjectFile)
+
+	s.write(c, file, `{"jbos": 2, "version": true}`)
+	cfg, err := Load(s.dir, s.known)
+	c.Assert(err, IsNil)
+	c.Check(cfg.Unknown(), DeepEquals, []Setting{
+		{Name: "jbos", Value: "2", Source: file},
+		{Name: "version", Value: "true", Source: file},
+	})
+
+	s.write(c, file, `{"jobs": "many"}`)
+	cfg, err = Load(s.dir, s.known)
+	c.Assert(err, IsNil)
+	c.Check(cfg.Apply(s.fs), ErrorMatches, ".*/.depman.json: invalid value 'many' for jobs: .*")
+
+	s.write(c, file, `{"jobs": [1]}`)
+	_, err = Load(s.dir, s.known)
+	c.Check(err, ErrorMatches, ".*/.depman.json: the value of 'jobs' must be a string, a number or a boolean")
+
+	s.write(c, file, `[`)
+	_, err = Load(s.dir, s.known)
+	c.Check(err, ErrorMatches, ".*/.depman.json: unexpected end of JSON input")
+}
@@ -8,21 +8,23 @@ import (
 
 	"github.com/vube/depman/colors"
 	"github.com/vube/depman/dep"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
 	"github.com/vube/depman/util"
 )
 
 // Default empty deps.json
 const template = "{}\n"
 
-// Create writes an empty deps.json at the location specified by path
-func Create(path string) {
+// Create writes an empty deps.json at the location specified by path, reporting it to r
+func Create(path string, r report.Reporter) {
 	if util.Exists(path) {
-		util.Fatal(colors.Red(dep.DepsFile + " already exists!"))
+		util.Abort(result.Config, dep.DepsFile+" already exists!")
 	}
-	util.Print(colors.Blue("Initializing:"))
+	r.Info(report.NoIndent, colors.Blue("Initializing:"))
 	err := ioutil.WriteFile(path, []byte(template), 0644)
 	if err == nil {
-		util.Print("Empty " + dep.DepsFile + " created (" + path + ")")
+		r.Info(report.NoIndent, "Empty "+dep.DepsFile+" created ("+path+")")
 	} else {
 		util.Fatal(colors.Red("Error creating "+dep.DepsFile+": "), err)
 	}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/vube/depman/colors"
+	"github.com/vube/depman/mirror"
 	"github.com/vube/depman/util"
 )
 
@@ -20,43 +21,61 @@ func (b *Bzr) LastCommit(d *Dependency, branch string) (hash string, err error)
 	out, err := c.CombinedOutput()
 
 	if err != nil {
-		util.Print("pwd: " + util.Pwd())
-		util.PrintIndent(colors.Red("bzr log --line"))
-		util.PrintIndent(colors.Red(string(out)))
-		util.PrintIndent(colors.Red(err.Error()))
-		util.Fatal("")
+		d.logger().Print("pwd: " + util.Pwd())
+		d.logger().PrintIndent(colors.Red("bzr log --line"))
+		d.logger().PrintIndent(colors.Red(string(out)))
+		return
 	}
 
 	hash = strings.Split(string(out), ":")[0]
 	return
 }
 
-//GetHead - Render a revspec to a commit ID
+//GetHead - Render a revspec to a commit ID, in the install path of d
 func (b *Bzr) GetHead(d *Dependency) (hash string, err error) {
-	var pwd string
+	c := exec.Command("bzr", "revno", d.Version)
+	c.Dir = d.Path()
+	out, err := c.CombinedOutput()
+	hash = strings.TrimSuffix(string(out), "\n")
 
-	pwd = util.Pwd()
-	util.Cd(d.Path())
-	defer util.Cd(pwd)
+	if err != nil {
+		d.logger().Print("path: " + d.Path())
+		d.logger().PrintIndent(colors.Red("bzr revno " + d.Version))
+		d.logger().PrintIndent(colors.Red(hash))
+		return
+	}
 
-	out, err := exec.Command("bzr", "revno", d.Version).CombinedOutput()
-	hash = strings.TrimSuffix(string(out), "\n")
+	return
+}
+
+// Revision returns the revision number currently checked out
+func (b *Bzr) Revision(d *Dependency) (revno string) {
+	revno, _ = d.logger().Capture(d.Path(), "bzr", "revno", "--tree")
+	return
+}
 
+// Versions returns the tags, a bzr repo has a single branch
+func (b *Bzr) Versions(d *Dependency) (names []string, err error) {
+	out, err := d.logger().Capture(d.Path(), "bzr", "tags")
 	if err != nil {
-		util.Print("pwd: " + util.Pwd())
-		util.PrintIndent(colors.Red("bzr revno " + d.Version))
-		util.PrintIndent(colors.Red(hash))
-		util.PrintIndent(colors.Red(err.Error()))
-		util.Fatal("")
+		return
 	}
 
+	// each line is the tag followed by its revision number
+	var tags []string
+	for _, l := range strings.Split(out, "\n") {
+		if f := strings.Fields(l); len(f) > 0 {
+			tags = append(tags, f[0])
+		}
+	}
+	names = uniqueLines(strings.Join(tags, "\n"))
 	return
 }
 
-// Clone clones a bzr repo
+// Clone uses go get to clone a bzr repo, through its mirror if mirrors are enabled
 func (b *Bzr) Clone(d *Dependency) (err error) {
 	if !util.Exists(d.Path()) {
-		err = util.RunCommand("go get -u " + d.Repo)
+		err = d.goGet(mirror.TypeBzr)
 	}
 	return
 }
@@ -66,18 +85,44 @@ func (b *Bzr) Update(d *Dependency) (err error) {
 	return
 }
 
-// Fetch pulls in a bzr repo
+// Fetch pulls in a bzr repo, or updates its mirror and pulls from that
 func (b *Bzr) Fetch(d *Dependency) (err error) {
-	err = util.RunCommand("bzr pull")
+	if !mirror.Enabled() {
+		err = d.runNetwork("bzr", "pull")
+		return
+	}
+
+	url, err := d.logger().Capture(d.Path(), "bzr", "config", "parent_location")
+	if err != nil {
+		d.logger().PrintIndent(colors.Red("Cannot find the parent location of " + d.Path()))
+		return
+	}
+
+	m, err := mirror.Update(d.logger(), mirror.TypeBzr, url)
+	if err != nil {
+		return
+	}
+
+	err = d.run("bzr", "pull", "--", m)
 	return
 }
 
 // Checkout updates a bzr repo
 func (b *Bzr) Checkout(d *Dependency) (err error) {
-	err = util.RunCommand("bzr up --revision " + d.Version)
+	err = d.run("bzr", "update", "--revision="+d.Version)
 	return
 }
 
+// HasVersion checks for d.Version in the local bzr branch
+func (b *Bzr) HasVersion(d *Dependency) bool {
+	return d.logger().Succeeds(d.Path(), "bzr", "revno", "--revision="+d.Version)
+}
+
+// IsPinned is always false, bzr revision numbers can change when branches are pulled
+func (b *Bzr) IsPinned(d *Dependency) bool {
+	return false
+}
+
 // Clean is a no-op for now
 func (b *Bzr) Clean(d *Dependency) {
 	return
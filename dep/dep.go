@@ -11,9 +11,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/vube/depman/colors"
+	"github.com/vube/depman/mirror"
+	"github.com/vube/depman/report"
 	"github.com/vube/depman/util"
 )
 
@@ -31,6 +35,12 @@ var (
 
 	// ErrMissingAlias indicates that a git-clone dependency requires an alias field
 	ErrMissingAlias = errors.New("dependency type git-clone requires alias field")
+
+	// ErrInvalidField indicates that a repo, version or alias field could be mistaken for a command line option
+	ErrInvalidField = errors.New("dependency fields may not start with '-'")
+
+	// ErrInvalidCacheTTL indicates that the cache-ttl field is not a valid duration
+	ErrInvalidCacheTTL = errors.New("dependency cache-ttl must be a duration such as 30m or 2h")
 )
 
 // DepsFile is the name of the dependency file
@@ -43,7 +53,13 @@ type Dependency struct {
 	Type      string         `json:"type"`
 	Alias     string         `json:"alias,omitempty"`
 	SkipCache bool           `json:"skip-cache,omitempty"`
+	CacheTTL  string         `json:"cache-ttl,omitempty"`
+	Depth     int            `json:"depth,omitempty"`
+	Sparse    []string       `json:"sparse,omitempty"`
 	VCS       VersionControl `json:"-"`
+
+	// Log receives the output of operations on this dependency, if nil output is written directly
+	Log *util.Logger `json:"-"`
 }
 
 // VersionControl is an interface that define a standard set of operations that can be completed by a version control system
@@ -58,9 +74,21 @@ type VersionControl interface {
 
 	Checkout(d *Dependency) (err error)
 
+	// Whether d.Version is present in the local repository, so it can be checked out without the network
+	HasVersion(d *Dependency) bool
+
+	// Whether d.Version is an immutable revision (i.e. a commit, not a branch or tag) which is present in the local repository
+	IsPinned(d *Dependency) bool
+
 	LastCommit(d *Dependency, branch string) (hash string, err error)
 	GetHead(d *Dependency) (to_return string, err error)
 
+	// The revision currently checked out, or the empty string if it can't be determined
+	Revision(d *Dependency) string
+
+	// The branches and tags in the local repository, sorted, for completing versions
+	Versions(d *Dependency) (names []string, err error)
+
 	Clean(d *Dependency)
 }
 
@@ -76,8 +104,9 @@ func New() (d DependencyMap) {
 	return
 }
 
-// Read reads filename and parses the content into a DependencyMap
-func Read(filename string) (deps DependencyMap, err error) {
+// Read reads filename and parses the content into a DependencyMap.
+// Problems with the dependencies are reported to r, which the Logger of each dependency reports to until it is replaced
+func Read(filename string, r report.Reporter) (deps DependencyMap, err error) {
 	deps.Map = make(map[string]*Dependency)
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -107,6 +136,18 @@ func Read(filename string) (deps DependencyMap, err error) {
 		}
 	}
 
+	for _, d := range deps.Map {
+		d.Log = util.NewLogger(r, false, 0)
+	}
+
+	// these fields are passed to VCS commands, so refuse anything that would be interpreted as an option
+	for name, d := range deps.Map {
+		err = d.Validate(name)
+		if err != nil {
+			return
+		}
+	}
+
 	for name, d := range deps.Map {
 		err := d.SetupVCS(name)
 		if err != nil {
@@ -134,12 +175,57 @@ func (d *DependencyMap) Write() (err error) {
 	return
 }
 
+// Validate checks that the repo, version and alias fields can be safely passed as command line arguments,
+// and that the cache-ttl field is a valid duration
+func (d *Dependency) Validate(name string) (err error) {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"repo", d.Repo},
+		{"version", d.Version},
+		{"alias", d.Alias},
+	}
+
+	for _, p := range d.Sparse {
+		fields = append(fields, struct {
+			name  string
+			value string
+		}{"sparse", p})
+	}
+
+	for _, f := range fields {
+		if strings.HasPrefix(f.value, "-") {
+			d.logger().PrintIndent(colors.Red("Error: Dependency " + name + ": Field '" + f.name + "' (" + f.value + ") may not start with '-'"))
+			err = ErrInvalidField
+			return
+		}
+	}
+
+	if _, e := time.ParseDuration(d.CacheTTL); d.CacheTTL != "" && e != nil {
+		d.logger().PrintIndent(colors.Red("Error: Dependency " + name + ": Field 'cache-ttl' (" + d.CacheTTL + ") is not a valid duration"))
+		err = ErrInvalidCacheTTL
+	}
+	return
+}
+
+// TTL returns the parsed "cache-ttl" field, ok is false if it is not set
+func (d *Dependency) TTL() (ttl time.Duration, ok bool) {
+	if d.CacheTTL == "" {
+		return
+	}
+
+	ttl, err := time.ParseDuration(d.CacheTTL)
+	ok = err == nil
+	return
+}
+
 // SetupVCS configures the VCS depending on the type
 func (d *Dependency) SetupVCS(name string) (err error) {
 	switch d.Type {
 	case TypeGitClone:
 		if d.Alias == "" {
-			util.PrintIndent(colors.Red("Error: Dependency " + name + ": Repo '" + d.Repo + "' Type '" + d.Type + "' requires 'alias' field"))
+			d.logger().PrintIndent(colors.Red("Error: Dependency " + name + ": Repo '" + d.Repo + "' Type '" + d.Type + "' requires 'alias' field"))
 			err = ErrMissingAlias
 			return
 		}
@@ -152,16 +238,27 @@ func (d *Dependency) SetupVCS(name string) (err error) {
 	case TypeHg:
 		d.VCS = new(Hg)
 	default:
-		util.PrintIndent(colors.Red(d.Repo + ": Unknown repository type (" + d.Type + "), skipping..."))
-		util.PrintIndent(colors.Red("Valid Repository types: " + TypeGit + ", " + TypeHg + ", " + TypeBzr + ", " + TypeGitClone))
+		d.logger().PrintIndent(colors.Red(d.Repo + ": Unknown repository type (" + d.Type + "), skipping..."))
+		d.logger().PrintIndent(colors.Red("Valid Repository types: " + TypeGit + ", " + TypeHg + ", " + TypeBzr + ", " + TypeGitClone))
 		err = ErrUnknownType
 	}
 
 	if d.Type != TypeGitClone && d.Alias != "" {
-		util.Print(colors.Yellow("Warning: " + d.Repo + ": 'alias' field only allowed in dependencies with type 'git-clone', skipping..."))
+		d.logger().Warn("Warning: " + d.Repo + ": 'alias' field only allowed in dependencies with type 'git-clone', skipping...")
 		d.Alias = ""
 	}
 
+	if d.Type != TypeGitClone && (d.Depth != 0 || len(d.Sparse) > 0) {
+		d.logger().Warn("Warning: " + d.Repo + ": 'depth' and 'sparse' fields only allowed in dependencies with type 'git-clone', skipping...")
+		d.Depth = 0
+		d.Sparse = nil
+	}
+
+	if d.Depth < 0 {
+		d.logger().Warn("Warning: " + d.Repo + ": 'depth' must be positive, skipping...")
+		d.Depth = 0
+	}
+
 	return
 }
 
@@ -196,8 +293,145 @@ func (d *Dependency) Path() (p string) {
 
 }
 
+// RootPath returns the directory of the repo holding d. Dependencies installed with go get may be a package inside
+// the repo, whose root is worked out from the import path (see mirror.RepoRoot) or else from the VCS directory of an
+// existing checkout. It is Path if neither is known
+func (d *Dependency) RootPath() string {
+	path := d.Path()
+	if d.Alias != "" {
+		return path
+	}
+
+	src := strings.TrimSuffix(path, filepath.FromSlash(d.Repo))
+	if root, _, ok := mirror.RepoRoot(d.Repo); ok {
+		return filepath.Join(src, filepath.FromSlash(root))
+	}
+
+	for dir := path; len(dir) > len(src); dir = filepath.Dir(dir) {
+		for _, vcs := range []string{".git", ".hg", ".bzr"} {
+			if util.Exists(filepath.Join(dir, vcs)) {
+				return dir
+			}
+		}
+	}
+	return path
+}
+
+// logger returns the Logger to use for operations on this dependency
+func (d *Dependency) logger() *util.Logger {
+	if d.Log == nil {
+		d.Log = util.DefaultLogger()
+	}
+	return d.Log
+}
+
+// run runs the command args in the dependency's directory
+func (d *Dependency) run(args ...string) (err error) {
+	return d.logger().Run(d.Path(), args...)
+}
+
+// runNetwork runs the command args, which talks to the remote, in the dependency's directory
+func (d *Dependency) runNetwork(args ...string) (err error) {
+	return d.logger().RunNetwork(d.Path(), nil, args...)
+}
+
+// clone runs the command args which creates d.Path().
+// If the command is interrupted or times out anything it wrote is removed so that the next run does not treat it as
+// installed, unless the directory already existed. Other failures, e.g. go get failing to build what it downloaded,
+// leave the directory as it is
+func (d *Dependency) clone(args ...string) (err error) {
+	return d.cloneTo(d.Path(), args...)
+}
+
+// cloneTo is clone for a command which creates dir
+func (d *Dependency) cloneTo(dir string, args ...string) (err error) {
+	existed := util.Exists(dir)
+	reset := func() {
+		if !existed {
+			d.removePartial(dir)
+		}
+	}
+
+	err = d.logger().RunNetwork("", reset, args...)
+	if err == util.ErrInterrupted || util.TimedOut(err) {
+		reset()
+	}
+	return
+}
+
+// removePartial removes an incomplete clone in dir
+func (d *Dependency) removePartial(dir string) {
+	if util.Exists(dir) {
+		d.logger().PrintIndent(colors.Yellow("Removing incomplete clone: " + dir))
+		os.RemoveAll(dir)
+	}
+}
+
+// goGet installs d with go get.
+// With mirrors the repo holding d is first cloned from its mirror, so that go get only has to build it. This needs the
+// url of the repo, when it can't be worked out from the import path (see mirror.RepoRoot) go get clones it instead
+func (d *Dependency) goGet(vcs string) (err error) {
+	_, url, ok := mirror.RepoRoot(d.Repo)
+	dir := d.RootPath()
+	if !mirror.Enabled() || !ok || util.Exists(dir) {
+		return d.clone("go", "get", "-u", "--", d.Repo)
+	}
+
+	m, err := mirror.Update(d.logger(), vcs, url)
+	if err != nil {
+		return
+	}
+
+	switch vcs {
+	case mirror.TypeGit:
+		err = d.cloneTo(dir, "git", "clone", "--", m, dir)
+	case mirror.TypeHg:
+		err = d.cloneTo(dir, "hg", "clone", "--", m, dir)
+	case mirror.TypeBzr:
+		err = d.cloneTo(dir, "bzr", "branch", "--", m, dir)
+	}
+	if err != nil {
+		return
+	}
+
+	// the clone is pointed back at url, where later fetches find the mirror again, a clone still pointing at the
+	// mirror would never be fetched from the network
+	switch vcs {
+	case mirror.TypeGit:
+		err = d.logger().Run(dir, "git", "remote", "set-url", "--", "origin", url)
+	case mirror.TypeHg:
+		err = ioutil.WriteFile(filepath.Join(dir, ".hg", "hgrc"), []byte("[paths]\ndefault = "+url+"\n"), 0644)
+	case mirror.TypeBzr:
+		err = d.logger().Run(dir, "bzr", "config", "--scope=branch", "parent_location="+url)
+	}
+	if err != nil {
+		d.removePartial(dir)
+		return
+	}
+
+	// the repo is present, so go get only fetches the packages it imports
+	return d.clone("go", "get", "--", d.Repo)
+}
+
+// uniqueLines returns the distinct non empty lines of the outputs, sorted
+func uniqueLines(outputs ...string) (lines []string) {
+	seen := make(map[string]bool)
+	for _, out := range outputs {
+		for _, l := range strings.Split(out, "\n") {
+			l = strings.TrimSpace(l)
+			if l != "" && !seen[l] {
+				seen[l] = true
+				lines = append(lines, l)
+			}
+		}
+	}
+	sort.Strings(lines)
+	return
+}
+
 //GetPath processes p and returns a clean path ending in deps.json
 func GetPath(p string) (result string) {
+	result = p
 	if !strings.HasSuffix(p, DepsFile) {
 		result = p + "/" + DepsFile
 	}
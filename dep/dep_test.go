@@ -3,7 +3,15 @@ package dep
 // Copyright 2013-2014 Vubeology, Inc.
 
 import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/report"
 	. "launchpad.net/gocheck"
 )
 
@@ -17,8 +25,9 @@ type DepSuite struct{}
 var _ = Suite(&DepSuite{})
 
 func (s *DepSuite) TestRead(c *C) {
+	r := report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard)
 
-	deps, err := Read("../tests/unit/unit.json")
+	deps, err := Read("../tests/unit/unit.json", r)
 
 	c.Assert(err, IsNil)
 	c.Assert(len(deps.Map), Equals, 3)
@@ -41,6 +50,63 @@ func (s *DepSuite) TestRead(c *C) {
 	c.Check(d.Version, Equals, "3")
 	c.Check(d.Type, Equals, "git")
 
-	deps, err = Read("./tests/unit/none")
+	deps, err = Read("./tests/unit/none", r)
 	c.Check(err, ErrorMatches, "open ./tests/unit/none: no such file or directory")
 }
+
+func (s *DepSuite) TestReadReporter(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+
+	file := filepath.Join(dir, "deps.json")
+	ioutil.WriteFile(file, []byte(`{"one": {"repo": "-repo", "type": "git"}}`), 0644)
+
+	var buf bytes.Buffer
+	_, err := Read(file, report.NewText(log.New(&buf, "", 0), &buf))
+	c.Check(err, Equals, ErrInvalidField)
+	c.Check(buf.String(), Matches, "(?s).*Dependency one: Field 'repo' \\(-repo\\) may not start with '-'.*")
+}
+
+func (s *DepSuite) TestGetPath(c *C) {
+	c.Check(GetPath("."), Equals, "deps.json")
+	c.Check(GetPath("/tmp/project/"), Equals, "/tmp/project/deps.json")
+
+	// a path which is already resolved is unchanged
+	c.Check(GetPath("deps.json"), Equals, "deps.json")
+	c.Check(GetPath(GetPath("/tmp/project")), Equals, "/tmp/project/deps.json")
+}
+
+func (s *DepSuite) TestRootPath(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+	src := filepath.Join(dir, "src")
+
+	d := &Dependency{Repo: "github.com/vube/depman/util", Type: TypeGit}
+	c.Check(d.RootPath(), Equals, filepath.Join(src, "github.com", "vube", "depman"))
+
+	// the root of other hosts is found from the checkout
+	d.Repo = "example.com/repo/pkg"
+	c.Check(d.RootPath(), Equals, filepath.Join(src, "example.com", "repo", "pkg"))
+	c.Assert(os.MkdirAll(filepath.Join(src, "example.com", "repo", ".hg"), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(src, "example.com", "repo", "pkg"), 0755), IsNil)
+	c.Check(d.RootPath(), Equals, filepath.Join(src, "example.com", "repo"))
+
+	d = &Dependency{Repo: "github.com/vube/depman", Type: TypeGitClone, Alias: "depman/util"}
+	c.Check(d.RootPath(), Equals, filepath.Join(src, "depman", "util"))
+}
+
+func (s *DepSuite) TestValidate(c *C) {
+	d := &Dependency{Repo: "github.com/vube/depman", Version: "master", Type: TypeGit}
+	c.Check(d.Validate("ok"), IsNil)
+
+	d.Version = "--upload-pack=touch /tmp/pwned"
+	c.Check(d.Validate("version"), Equals, ErrInvalidField)
+
+	d.Version = "master"
+	d.Repo = "-oProxyCommand=touch /tmp/pwned"
+	c.Check(d.Validate("repo"), Equals, ErrInvalidField)
+
+	d.Repo = "https://example.com/repo.git"
+	d.Alias = "-x"
+	c.Check(d.Validate("alias"), Equals, ErrInvalidField)
+}
@@ -5,31 +5,124 @@ package dep
 import (
 	"errors"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/vube/depman/colors"
+	"github.com/vube/depman/mirror"
 	"github.com/vube/depman/util"
 )
 
 // Git implements the VersionControl interface by using Git
 type Git struct{}
 
+// matches full or abbreviated git commit hashes
+var gitHash = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
 // Checkout uses the appropriate VCS to checkout the specified version of the code
+// A shallow clone is deepened if the version is not in the fetched history
 func (g *Git) Checkout(d *Dependency) (err error) {
-	err = util.RunCommand("git checkout " + d.Version)
-	if err != nil {
-		err = g.Fetch(d)
-		if err == nil {
-			err = util.RunCommand("git checkout " + d.Version)
+	if len(d.Sparse) > 0 {
+		err = g.setSparse(d)
+		if err != nil {
+			return
+		}
+	}
+
+	if d.Depth > 0 && !util.Offline() && !g.hasCommit(d) {
+		err = g.deepen(d)
+		if err != nil {
+			return
+		}
+	}
+
+	// the version may have been pushed since the last fetch, only the final failure is reported
+	args := []string{"git", "checkout", d.Version, "--"}
+	out, err := d.logger().Try(d.Path(), args...)
+	if err == nil {
+		return
+	}
+	if err == util.ErrInterrupted || util.Offline() {
+		return d.logger().Fail(args, out, err)
+	}
+
+	err = g.Fetch(d)
+	if err == nil {
+		err = d.run(args...)
+	}
+	return
+}
+
+// hasCommit checks if d.Version resolves to a commit whose history is present locally
+func (g *Git) hasCommit(d *Dependency) bool {
+	return g.HasVersion(d) && d.logger().Succeeds(d.Path(), "git", "rev-list", "--max-count=1", d.Version, "--")
+}
+
+// deepen fetches d.Version into a shallow clone, then doubles the depth of the history until it is present,
+// finally giving up and fetching the full history
+func (g *Git) deepen(d *Dependency) (err error) {
+	l := d.logger()
+
+	if gitHash.MatchString(d.Version) {
+		l.TryNetwork(d.Path(), nil, "git", "fetch", "--depth="+strconv.Itoa(d.Depth), "origin", d.Version)
+		if g.hasCommit(d) {
+			return
 		}
 	}
+
+	for depth := d.Depth * 2; depth <= d.Depth*16; depth *= 2 {
+		l.VerboseIndent("# " + d.Version + " is not in the shallow history, deepening to " + strconv.Itoa(depth))
+		_, err = l.TryNetwork(d.Path(), nil, "git", "fetch", "--depth="+strconv.Itoa(depth), "origin")
+		if err == util.ErrInterrupted {
+			return
+		}
+		if g.hasCommit(d) {
+			return nil
+		}
+	}
+
+	l.VerboseIndent("# " + d.Version + " is not in the shallow history, fetching the full history")
+	err = d.runNetwork("git", "fetch", "--unshallow", "origin")
 	return
 }
 
+// setSparse restricts the working tree of a sparse dependency to the paths in d.Sparse
+func (g *Git) setSparse(d *Dependency) (err error) {
+	err = d.run("git", "sparse-checkout", "init", "--cone")
+	if err == nil {
+		err = d.run(append([]string{"git", "sparse-checkout", "set", "--"}, d.Sparse...)...)
+	}
+	return
+}
+
+// HasVersion checks for d.Version as a local commit, branch or tag, or as a remote branch
+func (g *Git) HasVersion(d *Dependency) bool {
+	l := d.logger()
+	return l.Succeeds(d.Path(), "git", "rev-parse", "--verify", "--quiet", d.Version+"^{commit}") ||
+		l.Succeeds(d.Path(), "git", "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+d.Version+"^{commit}")
+}
+
+// IsPinned checks if d.Version is a commit hash, rather than a branch or tag, which is present locally
+func (g *Git) IsPinned(d *Dependency) bool {
+	if !gitHash.MatchString(d.Version) {
+		return false
+	}
+
+	l := d.logger()
+	for _, ref := range []string{"refs/heads/", "refs/tags/", "refs/remotes/origin/"} {
+		if l.Succeeds(d.Path(), "git", "show-ref", "--verify", "--quiet", ref+d.Version) {
+			return false
+		}
+	}
+
+	return l.Succeeds(d.Path(), "git", "cat-file", "-e", d.Version+"^{commit}")
+}
+
 // LastCommit retrieves the version number of the last commit on branch
 // Assumes that the current working directory is in the git repo
 func (g *Git) LastCommit(d *Dependency, branch string) (hash string, err error) {
-	if !g.isBranch(branch) {
+	if !g.isBranchIn(d.logger(), "", branch) {
 		err = errors.New("Branch '" + branch + "' is not a valid branch")
 		return
 	}
@@ -38,51 +131,83 @@ func (g *Git) LastCommit(d *Dependency, branch string) (hash string, err error)
 	out, err := c.CombinedOutput()
 
 	if err != nil {
-		util.Print("pwd: " + util.Pwd())
-		util.PrintIndent(colors.Red("git log -1 --format=%H"))
-		util.PrintIndent(colors.Red(string(out)))
-		util.PrintIndent(colors.Red(err.Error()))
-		util.Fatal("")
+		d.logger().Print("pwd: " + util.Pwd())
+		d.logger().PrintIndent(colors.Red("git log -1 --format=%H"))
+		d.logger().PrintIndent(colors.Red(string(out)))
+		return
 	}
 
 	hash = strings.Replace(string(out), "\n", "", -1)
 	return
 }
 
-//GetHead - Render a revspec to a commit ID
+//GetHead - Render a revspec to a commit ID, in the install path of d
 func (g *Git) GetHead(d *Dependency) (hash string, err error) {
-	var pwd string
-
-	pwd = util.Pwd()
-	util.Cd(d.Path())
-	defer util.Cd(pwd)
-
 	c := exec.Command("git", "rev-parse", d.Version)
+	c.Dir = d.Path()
 	out, err := c.CombinedOutput()
 	hash = strings.TrimSuffix(string(out), "\n")
 
 	if err != nil {
-		util.Print("pwd: " + util.Pwd())
-		util.PrintIndent(colors.Red("git rev-parse " + d.Version))
-		util.PrintIndent(colors.Red(string(hash)))
-		util.PrintIndent(colors.Red(err.Error()))
-		util.Fatal("")
+		d.logger().Print("path: " + d.Path())
+		d.logger().PrintIndent(colors.Red("git rev-parse " + d.Version))
+		d.logger().PrintIndent(colors.Red(string(hash)))
+		return
 	}
 
 	return
 }
 
+// Revision returns the commit currently checked out
+func (g *Git) Revision(d *Dependency) (hash string) {
+	hash, _ = d.logger().Capture(d.Path(), "git", "rev-parse", "HEAD")
+	return
+}
+
+// Versions returns the local and remote branches and the tags, remote branches without the name of the remote
+func (g *Git) Versions(d *Dependency) (names []string, err error) {
+	out, err := d.logger().Capture(d.Path(), "git", "for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes", "refs/tags")
+	if err != nil {
+		return
+	}
+
+	var refs []string
+	for _, ref := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(ref, "refs/remotes/"):
+			// e.g. refs/remotes/origin/develop
+			parts := strings.SplitN(ref, "/", 4)
+			if len(parts) == 4 && parts[3] != "HEAD" {
+				refs = append(refs, parts[3])
+			}
+		default:
+			refs = append(refs, strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/"))
+		}
+	}
+	names = uniqueLines(strings.Join(refs, "\n"))
+	return
+}
+
 // IsBranch determines if a version (branch, commit hash, tag) is a branch (i.e. can we pull from the remote).
 // Assumes we are already in a sub directory of the repo
 func (g *Git) isBranch(name string) (result bool) {
+	return g.isBranchIn(util.DefaultLogger(), "", name)
+}
+
+// isBranchIn is isBranch for the repo in dir, messages are written to l
+func (g *Git) isBranchIn(l *util.Logger, dir string, name string) (result bool) {
 	c := exec.Command("git", "branch", "-r")
+	c.Dir = dir
 	out, err := c.CombinedOutput()
 
 	if err != nil {
-		util.Print("pwd: " + util.Pwd())
-		util.PrintIndent(colors.Red("git branch -r"))
-		util.PrintIndent(colors.Red(string(out)))
-		util.PrintIndent(colors.Red(err.Error()))
+		if dir == "" {
+			dir = util.Pwd()
+		}
+		l.Print("pwd: " + dir)
+		l.PrintIndent(colors.Red("git branch -r"))
+		l.PrintIndent(colors.Red(string(out)))
+		l.PrintIndent(colors.Red(err.Error()))
 		return false
 	}
 
@@ -109,33 +234,143 @@ func (g *Git) isBranch(name string) (result bool) {
 // Clone clones d.Repo into d.Path() if d.Path does not exist, otherwise it will cd to d.Path() and run git fetch
 func (g *Git) Clone(d *Dependency) (err error) {
 	if !util.Exists(d.Path()) {
-		if d.Type == TypeGitClone {
-			err = util.RunCommand("git clone " + d.Repo + " " + d.Path())
+		if d.Type == TypeGitClone && mirror.Enabled() {
+			err = g.cloneFromMirror(d)
+		} else if d.Type == TypeGitClone {
+			err = d.clone(g.cloneArgs(d, d.Repo)...)
 		} else {
-			err = util.RunCommand("go get -u " + d.Repo)
+			err = d.goGet(mirror.TypeGit)
 		}
 	}
 	return
 }
 
+// cloneArgs returns the command to clone d from source, as a shallow and/or sparse clone if configured.
+// Shallow clones include all branches, so that any version can be checked out,
+// sparse clones are not checked out until the sparse paths have been set
+func (g *Git) cloneArgs(d *Dependency, source string) (args []string) {
+	args = []string{"git", "clone"}
+	if d.Depth > 0 {
+		args = append(args, "--depth="+strconv.Itoa(d.Depth), "--no-single-branch")
+	}
+	if len(d.Sparse) > 0 {
+		args = append(args, "--no-checkout")
+	}
+	return append(args, "--", source, d.Path())
+}
+
+// cloneFromMirror updates the mirror of d.Repo and clones it locally, the objects are hard linked rather than copied.
+// The origin is then pointed back at d.Repo
+func (g *Git) cloneFromMirror(d *Dependency) (err error) {
+	m, err := mirror.Update(d.logger(), mirror.TypeGit, d.Repo)
+	if err != nil {
+		return
+	}
+
+	// a local clone ignores --depth, using a file url makes git honour it
+	if d.Depth > 0 {
+		m = "file://" + m
+	}
+
+	err = d.clone(g.cloneArgs(d, m)...)
+	if err == nil {
+		err = d.run("git", "remote", "set-url", "--", "origin", d.Repo)
+	}
+	return
+}
+
 // Update updates a git repo
+// When using mirrors the branch was already brought up to date by Fetch, so it is merged without contacting the remote
 func (g *Git) Update(d *Dependency) (err error) {
-	if g.isBranch(d.Version) {
-		err = util.RunCommand("git pull")
+	if g.isBranchIn(d.logger(), d.Path(), d.Version) {
+		if mirror.Enabled() {
+			err = d.run("git", "merge", "refs/remotes/origin/"+d.Version)
+		} else {
+			err = d.runNetwork("git", "pull", "origin", d.Version)
+		}
 	}
 	return
 }
 
-// Fetch fetches a git repo
+// Fetch fetches a git repo, or updates its mirror and fetches from that
+// Without a mirror only the ref needed by d.Version is fetched, falling back to fetching everything if that fails
 func (g *Git) Fetch(d *Dependency) (err error) {
-	err = util.RunCommand("git fetch origin")
+	if !mirror.Enabled() {
+		if g.fetchTargeted(d) {
+			return
+		}
+		err = d.runNetwork(append(g.fetchArgs(d), "origin")...)
+		return
+	}
+
+	url := d.Repo
+	if d.Type != TypeGitClone {
+		url, err = d.logger().Capture(d.Path(), "git", "config", "--get", "remote.origin.url")
+		if err != nil {
+			d.logger().PrintIndent(colors.Red("Cannot find the remote url of " + d.Path()))
+			return
+		}
+	}
+
+	m, err := mirror.Update(d.logger(), mirror.TypeGit, url)
+	if err != nil {
+		return
+	}
+
+	if d.Depth > 0 {
+		m = "file://" + m
+	}
+
+	err = d.run(append(g.fetchArgs(d), "--prune", "--", m, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")...)
 	return
 }
 
+// fetchArgs returns the start of a fetch command, limited to the configured depth for shallow clones
+func (g *Git) fetchArgs(d *Dependency) []string {
+	if d.Depth > 0 {
+		return []string{"git", "fetch", "--depth=" + strconv.Itoa(d.Depth)}
+	}
+	return []string{"git", "fetch"}
+}
+
+// fetchTargeted fetches only the ref d.Version refers to, returns false if it could not be fetched.
+// A commit is fetched by hash, otherwise the version is fetched as a branch, then as a tag,
+// starting with whichever already exists locally
+func (g *Git) fetchTargeted(d *Dependency) bool {
+	l := d.logger()
+	branch := "+refs/heads/" + d.Version + ":refs/remotes/origin/" + d.Version
+	tag := "+refs/tags/" + d.Version + ":refs/tags/" + d.Version
+
+	var refspecs []string
+	switch {
+	case l.Succeeds(d.Path(), "git", "show-ref", "--verify", "--quiet", "refs/remotes/origin/"+d.Version):
+		refspecs = []string{branch}
+	case l.Succeeds(d.Path(), "git", "show-ref", "--verify", "--quiet", "refs/tags/"+d.Version):
+		refspecs = []string{tag}
+	case gitHash.MatchString(d.Version):
+		refspecs = []string{d.Version, branch, tag}
+	default:
+		refspecs = []string{branch, tag}
+	}
+
+	for _, r := range refspecs {
+		_, err := l.TryNetwork(d.Path(), nil, append(g.fetchArgs(d), "origin", r)...)
+		if err == nil {
+			return true
+		}
+		if err == util.ErrInterrupted {
+			return false
+		}
+	}
+
+	l.VerboseIndent("# targeted fetch failed, fetching everything")
+	return false
+}
+
 // Clean cleans a git repo: `git reset --hard HEAD ; git clean -fd`
 func (g *Git) Clean(d *Dependency) {
-	util.PrintIndent(colors.Red("Cleaning:") + colors.Blue(" git reset --hard HEAD"))
-	util.RunCommand("git reset --hard HEAD")
-	util.RunCommand("git clean -fd")
+	d.logger().PrintIndent(colors.Red("Cleaning:") + colors.Blue(" git reset --hard HEAD"))
+	d.run("git", "reset", "--hard", "HEAD")
+	d.run("git", "clean", "-fd")
 	return
 }
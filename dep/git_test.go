@@ -4,12 +4,17 @@ package dep
 
 import (
 	"bytes"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/vube/depman/colors"
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/mirror"
+	"github.com/vube/depman/result"
 	"github.com/vube/depman/util"
 	. "launchpad.net/gocheck"
 )
@@ -59,3 +64,215 @@ func (s *GitSuite) TestIsGitBranch(c *C) {
 		"exit status 128\n"
 	c.Check(s.buf.String(), Equals, output)
 }
+
+func (s *GitSuite) TestIsPinned(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+
+	repo := filepath.Join(dir, "src", "repo")
+	os.MkdirAll(repo, 0755)
+
+	fixture.Git(c, repo, "init", "-q")
+	fixture.Git(c, repo, "commit", "-q", "--allow-empty", "-m", "first")
+	hash := fixture.Git(c, repo, "rev-parse", "HEAD")
+	fixture.Git(c, repo, "branch", "abcdef1")
+
+	g := new(Git)
+	d := &Dependency{Repo: "repo", Type: TypeGit}
+
+	d.Version = hash
+	c.Check(g.IsPinned(d), Equals, true)
+
+	d.Version = hash[:10]
+	c.Check(g.IsPinned(d), Equals, true)
+
+	// branches are never pinned, even if they look like a hash
+	d.Version = "abcdef1"
+	c.Check(g.IsPinned(d), Equals, false)
+
+	d.Version = "master"
+	c.Check(g.IsPinned(d), Equals, false)
+
+	// not present locally
+	d.Version = "0123456789abcdef0123456789abcdef01234567"
+	c.Check(g.IsPinned(d), Equals, false)
+}
+
+func (s *GitSuite) TestFetchTargeted(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+
+	origin := filepath.Join(dir, "origin")
+	clone := filepath.Join(dir, "src", "clone")
+	os.MkdirAll(origin, 0755)
+
+	fixture.Git(c, origin, "init", "-q")
+	fixture.Git(c, origin, "commit", "-q", "--allow-empty", "-m", "first")
+	fixture.Git(c, dir, "clone", "-q", origin, clone)
+
+	fixture.Git(c, origin, "branch", "wanted")
+	fixture.Git(c, origin, "checkout", "-q", "-b", "other")
+	fixture.Git(c, origin, "commit", "-q", "--allow-empty", "-m", "second")
+	fixture.Git(c, origin, "tag", "v1")
+	fixture.Git(c, origin, "checkout", "-q", "-")
+
+	g := new(Git)
+	d := &Dependency{Repo: "clone", Type: TypeGit}
+
+	d.Version = "wanted"
+	c.Check(g.Fetch(d), IsNil)
+	c.Check(fixture.Git(c, clone, "branch", "-r"), Matches, `(?s).*origin/wanted.*`)
+	c.Check(fixture.Git(c, clone, "branch", "-r"), Not(Matches), `(?s).*origin/other.*`)
+	c.Check(fixture.Git(c, clone, "tag"), Equals, "")
+
+	d.Version = "v1"
+	c.Check(g.Fetch(d), IsNil)
+	c.Check(fixture.Git(c, clone, "tag"), Equals, "v1")
+	c.Check(fixture.Git(c, clone, "branch", "-r"), Not(Matches), `(?s).*origin/other.*`)
+}
+
+func (s *GitSuite) TestCheckoutAfterFetch(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+	defer result.Reset()
+
+	origin := filepath.Join(dir, "origin")
+	clone := filepath.Join(dir, "src", "clone")
+	os.MkdirAll(origin, 0755)
+	fixture.Git(c, origin, "init", "-q")
+	fixture.Git(c, origin, "commit", "-q", "--allow-empty", "-m", "first")
+	fixture.Git(c, dir, "clone", "-q", origin, clone)
+
+	// pushed since the clone, so only the second attempt succeeds
+	fixture.Git(c, origin, "checkout", "-q", "-b", "later")
+	fixture.Git(c, origin, "commit", "-q", "--allow-empty", "-m", "second")
+	head := fixture.Git(c, origin, "rev-parse", "HEAD")
+
+	d := &Dependency{Repo: "clone", Type: TypeGit, Version: "later"}
+	c.Assert(new(Git).Checkout(d), IsNil, Commentf("%s", s.buf))
+	c.Check(fixture.Git(c, clone, "rev-parse", "HEAD"), Equals, head)
+	c.Check(result.ExitCode(), Equals, 0)
+
+	d.Version = "missing"
+	c.Check(new(Git).Checkout(d), NotNil)
+	c.Check(result.ExitCode(), Equals, 4)
+}
+
+func (s *GitSuite) TestShallowSparseClone(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+
+	origin := filepath.Join(dir, "origin")
+	os.MkdirAll(filepath.Join(origin, "wanted"), 0755)
+	os.MkdirAll(filepath.Join(origin, "unwanted"), 0755)
+	ioutil.WriteFile(filepath.Join(origin, "wanted", "a"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(origin, "unwanted", "b"), []byte("b"), 0644)
+
+	fixture.Git(c, origin, "init", "-q")
+	fixture.Git(c, origin, "add", ".")
+	fixture.Git(c, origin, "commit", "-q", "-m", "first")
+	first := fixture.Git(c, origin, "rev-parse", "HEAD")
+	for i := 0; i < 5; i++ {
+		fixture.Git(c, origin, "commit", "-q", "--allow-empty", "-m", "more")
+	}
+
+	g := new(Git)
+	d := &Dependency{Repo: "file://" + origin, Type: TypeGitClone, Alias: "clone", Version: first, Depth: 1, Sparse: []string{"wanted"}}
+	path := filepath.Join(dir, "src", "clone")
+
+	c.Assert(g.Clone(d), IsNil)
+	c.Check(fixture.Git(c, path, "rev-list", "--count", "--all"), Equals, "1")
+
+	// the pinned commit is not in the shallow history, so it is deepened
+	c.Assert(g.Checkout(d), IsNil)
+	c.Check(fixture.Git(c, path, "rev-parse", "HEAD"), Equals, first)
+	c.Check(util.Exists(filepath.Join(path, "wanted", "a")), Equals, true)
+	c.Check(util.Exists(filepath.Join(path, "unwanted", "b")), Equals, false)
+}
+
+func (s *GitSuite) TestVersions(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+
+	origin := filepath.Join(dir, "origin")
+	clone := filepath.Join(dir, "src", "clone")
+	os.MkdirAll(origin, 0755)
+
+	fixture.Git(c, origin, "init", "-q", "-b", "master")
+	fixture.Git(c, origin, "commit", "-q", "--allow-empty", "-m", "first")
+	fixture.Git(c, origin, "branch", "develop")
+	fixture.Git(c, origin, "tag", "v1")
+	fixture.Git(c, dir, "clone", "-q", origin, clone)
+	fixture.Git(c, clone, "branch", "local")
+
+	d := &Dependency{Repo: "clone", Type: TypeGit}
+	names, err := new(Git).Versions(d)
+	c.Assert(err, IsNil)
+	c.Check(names, DeepEquals, []string{"develop", "local", "master", "v1"})
+}
+
+func (s *GitSuite) TestCloneCleanup(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+	util.SetRetries(0, 0)
+	defer util.SetRetries(2, 2*time.Second)
+
+	d := &Dependency{Repo: "example.com/clone", Type: TypeGitClone, Alias: "clone"}
+	path := filepath.Join(dir, "src", "clone")
+	write := "mkdir -p " + path + " && touch " + path + "/file"
+
+	// a failure after the download, e.g. a build error, keeps what was downloaded
+	c.Check(d.clone("sh", "-c", write+" && exit 1"), NotNil)
+	c.Check(util.Exists(path+"/file"), Equals, true)
+
+	util.SetCommandTimeout(200 * time.Millisecond)
+	defer util.SetCommandTimeout(0)
+
+	// a directory which existed before is never removed
+	c.Check(util.TimedOut(d.clone("sh", "-c", write+" && exec sleep 5")), Equals, true)
+	c.Check(util.Exists(path+"/file"), Equals, true)
+
+	// an incomplete clone is
+	c.Assert(os.RemoveAll(path), IsNil)
+	c.Check(util.TimedOut(d.clone("sh", "-c", write+" && exec sleep 5")), Equals, true)
+	c.Check(util.Exists(path), Equals, false)
+}
+
+func (s *GitSuite) TestGoGetFromMirror(c *C) {
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+
+	mirror.SetDir(filepath.Join(dir, "mirrors"))
+	mirror.SetEnabled(true)
+	defer mirror.SetDir(mirror.DefaultDir())
+	defer mirror.SetEnabled(false)
+
+	// the mirror of the remote is already present, so nothing is downloaded
+	origin := filepath.Join(dir, "origin")
+	os.MkdirAll(filepath.Join(origin, "pkg"), 0755)
+	ioutil.WriteFile(filepath.Join(origin, "pkg", "pkg.go"), []byte("package pkg\n"), 0644)
+	fixture.Git(c, origin, "init", "-q")
+	fixture.Git(c, origin, "add", ".")
+	fixture.Git(c, origin, "commit", "-q", "-m", "first")
+
+	url := "https://example.com/team/repo.git"
+	fixture.Git(c, dir, "clone", "-q", "--mirror", "--", origin, mirror.Path(url))
+
+	// go get only builds what is present, which a fake go stands in for
+	bin := filepath.Join(dir, "bin")
+	os.MkdirAll(bin, 0755)
+	ioutil.WriteFile(filepath.Join(bin, "go"), []byte("#!/bin/sh\necho \"$@\" > "+filepath.Join(dir, "args")+"\n"), 0755)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	d := &Dependency{Repo: "example.com/team/repo.git/pkg", Type: TypeGit}
+	c.Assert(new(Git).Clone(d), IsNil, Commentf("%s", s.buf))
+
+	path := filepath.Join(dir, "src", "example.com", "team", "repo.git")
+	c.Check(util.Exists(filepath.Join(path, "pkg", "pkg.go")), Equals, true)
+
+	c.Check(fixture.Git(c, path, "config", "--get", "remote.origin.url"), Equals, url)
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	c.Assert(err, IsNil)
+	c.Check(string(args), Equals, "get -- example.com/team/repo.git/pkg\n")
+}
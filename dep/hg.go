@@ -4,15 +4,20 @@ package dep
 
 import (
 	"os/exec"
+	"regexp"
 	"strings"
 
 	"github.com/vube/depman/colors"
+	"github.com/vube/depman/mirror"
 	"github.com/vube/depman/util"
 )
 
 // Hg implements the VersionControl interface by using Mercurial
 type Hg struct{}
 
+// matches full or short mercurial changeset hashes
+var hgHash = regexp.MustCompile(`^[0-9a-f]{12,40}$`)
+
 // LastCommit retrieves the version number of the last commit on branch
 // Assumes that the current working directory is in the hg repo
 func (h *Hg) LastCommit(d *Dependency, branch string) (hash string, err error) {
@@ -20,68 +25,115 @@ func (h *Hg) LastCommit(d *Dependency, branch string) (hash string, err error) {
 	out, err := c.CombinedOutput()
 
 	if err != nil {
-		util.Print("pwd: " + util.Pwd())
-		util.PrintIndent(colors.Red("hg log --template='{node}\n' --limit=1"))
-		util.PrintIndent(colors.Red(string(out)))
-		util.PrintIndent(colors.Red(err.Error()))
-		util.Fatal("")
+		d.logger().Print("pwd: " + util.Pwd())
+		d.logger().PrintIndent(colors.Red("hg log --template='{node}\n' --limit=1"))
+		d.logger().PrintIndent(colors.Red(string(out)))
+		return
 	}
 
 	hash = strings.Replace(string(out), "\n", "", -1)
 	return
 }
 
-// Clone uses go get to clone a mercurial repo
+// Clone uses go get to clone a mercurial repo, through its mirror if mirrors are enabled
 func (h *Hg) Clone(d *Dependency) (err error) {
 	if !util.Exists(d.Path()) {
-		err = util.RunCommand("go get -u " + d.Repo)
+		err = d.goGet(mirror.TypeHg)
 	}
 	return
 }
 
-// Fetch fetches a mercurial repo
+// Fetch fetches a mercurial repo, or updates its mirror and pulls from that
+// Without a mirror only d.Version is pulled, falling back to pulling everything if that fails
 func (h *Hg) Fetch(d *Dependency) (err error) {
-	err = util.RunCommand("hg pull")
+	if !mirror.Enabled() {
+		_, err = d.logger().TryNetwork(d.Path(), nil, "hg", "pull", "--rev", d.Version)
+		if err == nil || err == util.ErrInterrupted {
+			return
+		}
+
+		d.logger().VerboseIndent("# targeted pull failed, pulling everything")
+		err = d.runNetwork("hg", "pull")
+		return
+	}
+
+	url, err := d.logger().Capture(d.Path(), "hg", "paths", "default")
+	if err != nil {
+		d.logger().PrintIndent(colors.Red("Cannot find the default path of " + d.Path()))
+		return
+	}
+
+	m, err := mirror.Update(d.logger(), mirror.TypeHg, url)
+	if err != nil {
+		return
+	}
+
+	err = d.run("hg", "pull", "--", m)
 	return
 }
 
 // Update updates a mercurial repo
 func (h *Hg) Update(d *Dependency) (err error) {
-	err = util.RunCommand("hg up " + d.Version)
+	err = d.run("hg", "update", "--rev", d.Version)
 	return
 }
 
 // Checkout updates a mercurial repo
 func (h *Hg) Checkout(d *Dependency) (err error) {
-	err = util.RunCommand("hg up " + d.Version)
+	err = d.run("hg", "update", "--rev", d.Version)
 	return
 }
 
+// HasVersion checks for d.Version in the local mercurial repo
+func (h *Hg) HasVersion(d *Dependency) bool {
+	return d.logger().Succeeds(d.Path(), "hg", "log", "--rev", d.Version, "--limit", "1")
+}
+
+// IsPinned checks if d.Version is a changeset hash which is present locally
+func (h *Hg) IsPinned(d *Dependency) bool {
+	return hgHash.MatchString(d.Version) && h.HasVersion(d)
+}
+
 //Clean cleans a mercurial repo
 func (h *Hg) Clean(d *Dependency) {
-	util.PrintIndent(colors.Red("Cleaning:") + colors.Blue(" hg up --clean "+d.Version))
-	util.RunCommand("hg up --clean " + d.Version)
+	d.logger().PrintIndent(colors.Red("Cleaning:") + colors.Blue(" hg up --clean "+d.Version))
+	d.run("hg", "update", "--clean", "--rev", d.Version)
 	return
 }
 
-//GetHead - Render a revspec to a commit ID
+//GetHead - Render a revspec to a commit ID, in the install path of d
 func (h *Hg) GetHead(d *Dependency) (hash string, err error) {
-	var pwd string
+	c := exec.Command("hg", "id", "-i")
+	c.Dir = d.Path()
+	out, err := c.CombinedOutput()
+	hash = strings.TrimSuffix(string(out), "\n")
 
-	pwd = util.Pwd()
-	util.Cd(d.Path())
-	defer util.Cd(pwd)
+	if err != nil {
+		d.logger().Print("path: " + d.Path())
+		d.logger().PrintIndent(colors.Red("hg id -i"))
+		d.logger().PrintIndent(colors.Red(hash))
+		return
+	}
 
-	out, err := exec.Command("hg", "id", "-i").CombinedOutput()
-	hash = strings.TrimSuffix(string(out), "\n")
+	return
+}
 
+// Versions returns the named branches and the tags
+func (h *Hg) Versions(d *Dependency) (names []string, err error) {
+	branches, err := d.logger().Capture(d.Path(), "hg", "branches", "-q")
+	if err != nil {
+		return
+	}
+	tags, err := d.logger().Capture(d.Path(), "hg", "tags", "-q")
 	if err != nil {
-		util.Print("pwd: " + util.Pwd())
-		util.PrintIndent(colors.Red("hg id -i"))
-		util.PrintIndent(colors.Red(hash))
-		util.PrintIndent(colors.Red(err.Error()))
-		util.Fatal("")
+		return
 	}
+	names = uniqueLines(branches, tags)
+	return
+}
 
+// Revision returns the changeset currently checked out
+func (h *Hg) Revision(d *Dependency) (hash string) {
+	hash, _ = d.logger().Capture(d.Path(), "hg", "id", "-i")
 	return
 }
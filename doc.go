@@ -60,34 +60,108 @@ Commands
 [branch]
 
 * `show-frozen` Show dependencies as resolved to commit IDs.
-Use the `--recursive` flag to descend into dependencies depth-first.
+Use the `--recursive` flag (after the command) to descend into dependencies
+depth-first.
 
-* `help` Display help message
+* `cache list` Show each entry in the cache, with its age and whether it is
+stale
+
+* `cache evict [repo|nickname]...` Remove entries from the cache, so they are
+fetched on the next install
+
+* `cache touch [repo|nickname]...` Mark entries in the cache as fresh (all
+entries if none are given)
+
+* `mirror gc [days]` Remove shared mirrors which have not been used in [days]
+days (default 30)
+
+* `doctor` Check GOPATH, the version control systems, the cache and access to
+the remotes
+
+* `config show` Show the value of each option, and where it was set
+
+* `help [command]` Display help, or the help and options of [command]
+
+* `completion [shell]` Write the completion script for [shell] (bash, zsh or
+fish) to stdout
 
 
 Options
 
-* `-clean=false`: Remove changes to code in dependencies
+Global options go before the command, the options of a command after it, e.g.
+`depman --verbose install --clean`. Run `depman help [command]` to list the
+options of a command.
 
 * `-clear-cache=false`: Delete the time based cache
 
+* `-command-timeout=0`: Maximum time a single VCS command may run (e.g. 5m), 0
+means no limit
+
 * `-debug=false`: Display debug messages. Implies --verbose
 
 * `-help=false`: Display help
 
 * `-no-colors=false`: Disable colors
 
+* `-no-progress=false`: Display each dependency instead of a progress line when
+stderr is a terminal
+
+* `-offline=false`: Never access the network, only check out versions already
+present locally (also set by DEPMAN_OFFLINE=1)
+
+* `-output="text"`: Output format, 'text' or 'json' (newline delimited JSON
+events on stdout, text messages still go to stderr)
+
 * `-path="."`: Directory or full path to deps.json
 
-* `-silent=false`: Don't display normal output. Overrides --debug and --verbose
+* `-retries=2`: Number of times to retry network operations which fail with a
+network error
 
-* `-skip-cache=false`: Skip the time based cache for this run only
+* `-retry-wait=2s`: Time to wait before the first retry, doubled for each
+following retry
+
+* `-silent=false`: Don't display normal output. Overrides --debug and --verbose
 
 * `-verbose=false`: Display commands as they are run, and other informative
 messages
 
 * `-version=false`: Display version number
 
+The options of `install`, `add` and `update`, which also accept the options of
+`cache` and `mirror`:
+
+* `-clean=false`: Remove changes to code in dependencies
+
+* `-jobs=1`: Number of dependencies to install in parallel
+
+* `-lock-timeout=5m0s`: Time to wait for another depman process to finish with
+a dependency (0 waits forever, with a message every minute)
+
+The options of `install` only:
+
+* `-profile=false`: Display the slowest dependencies and operations at the end
+of the install
+
+* `-profile-trace=""`: Write the time taken by each operation to this file as a
+Chrome trace (implies --profile)
+
+The options of `cache`:
+
+* `-cache-ttl=1h0m0s`: Time before a cached dependency is fetched again (also
+set by DEPMAN_CACHE_TTL)
+
+* `-skip-cache=false`: Skip the time based cache for this run only
+
+The options of `mirror`:
+
+* `-mirror=false`: Clone and fetch through a shared local mirror of each repo
+
+* `-mirror-dir="~/.depman/mirrors"`: Directory holding the shared mirrors
+
+The options of `show-frozen`:
+
+* `-recursive=false`: descend recursively (depth-first) into dependencies
+
 
 Basic Algorithm
 
@@ -109,6 +183,163 @@ skipped (this just saves some time and prevents infinite recursion). Duplicated
 dependencies with _different_ versions cause a fatal error and must be fixed by
 the developer.
 
+When a dependency fails to install, depman carries on with the others and exits
+non-zero at the end, after printing a table of the failures with the
+dependency, the operation which failed, the deps.json declaring it, the command
+and its error.
+
+
+Terminal Output
+
+When stderr is a terminal, install displays a single progress line instead of a
+line for each dependency: the number of dependencies started out of those
+found so far, the dependency being installed, the command it is running and the
+time elapsed. Warnings and failures are displayed above it. `--verbose`,
+`--silent`, `--output=json` and `--no-progress` display each dependency as
+before.
+
+Colors are disabled when stdout or stderr is not a terminal, or when the
+`NO_COLOR` environment variable is set. `FORCE_COLOR=1` keeps them when the
+output is redirected, e.g. to a CI log, `--no-colors` always disables them.
+
+
+JSON Output
+
+With `--output=json` depman writes one JSON object per line to stdout, text
+messages still go to stderr. Each object has an `event` field:
+
+* `start`: a dependency is being installed
+
+* `command`: a VCS command was run, with its `command`, `duration` and `error`
+
+* `fetched`: a dependency was fetched
+
+* `checked-out`: a dependency was checked out, `head` is the resolved revision
+
+* `skipped-duplicate`: a dependency was already installed elsewhere in the tree
+
+* `error`: a dependency failed, with the `op` which failed, the `command`, its
+`output` and the `error`
+
+* `check`: a check of `doctor`, with its `group`, `state` (ok, FAIL or skip),
+`name`, `detail` and `remedy`
+
+* `cache-entry`: an entry of `cache list`, with its `key`, `repo`, `path`,
+`time`, `revision`, `error`, `age` and `ttl` in seconds, and `stale` flag
+
+* `cache-evicted`, `cache-touched`: the `key` of an entry removed by `cache evict`
+or refreshed by `cache touch`
+
+* `option`: an option of `config show`, with its `name`, `value` and `source`
+
+* `summary`: always the last line, with the `exit_code`, the total `duration` and
+the list of `errors`
+
+Events about a dependency include its `name`, `repo`, `version` and `stale`
+flag, and the `duration` in seconds since it was started. For example:
+
+	{"event":"start","name":"gocheck","repo":"launchpad.net/gocheck","version":"r2013.09.23","stale":true}
+	{"event":"checked-out","name":"gocheck","repo":"launchpad.net/gocheck","version":"r2013.09.23","head":"156","stale":true,"duration":1.52}
+	{"event":"summary","exit_code":0,"duration":1.61,"errors":[]}
+
+`show-frozen --output=json` writes a single JSON array of objects with the
+`name`, `repo` and frozen `version` of each dependency instead.
+
+The text and JSON output are both produced by a `report.Reporter`, programs
+embedding depman can set their own in `api.Options` to receive the same events.
+
+
+Exit Codes
+
+depman exits with one of the following codes, so scripts can tell why it
+failed:
+
+* `0`: success
+
+* `1`: any other error
+
+* `2`: configuration error, such as a missing or invalid deps.json, or an
+invalid command line
+
+* `3`: version conflict, the same dependency is required at two different
+versions
+
+* `4`: a VCS command failed, including network failures
+
+* `5`: a VCS command refused to overwrite local changes in a dependency
+
+* `6`: verification failed, a dependency pinned to a commit is not at that commit
+after it was checked out
+
+* `7`: offline, a dependency requires network access with `--offline`
+
+* `130`: depman was interrupted
+
+If several kinds of errors occur, the lowest code (other than 1) is used.
+
+
+Interrupts and Timeouts
+
+VCS commands are run without a terminal, so a command which needs a password or
+an unknown host key fails instead of waiting for input: git runs with
+`GIT_TERMINAL_PROMPT=0`, hg with `HGPLAIN=1` and `ui.interactive=false`, bzr with
+`BZR_SSH=openssh`, and ssh's prompts are answered by `SSH_ASKPASS=false`. The ssh
+command configured for git (`core.sshCommand`, `GIT_SSH_COMMAND`) is kept.
+The `--command-timeout` flag limits how long any single command may run.
+
+Clones, fetches and pulls which fail with a network error (for example a DNS
+failure or a dropped connection) are retried `--retries` times, waiting
+`--retry-wait` before the first retry and twice as long before each following
+retry. Commands stopped by `--command-timeout` are not retried.
+
+Pressing Ctrl-C stops the running commands, removes any partially cloned
+dependencies, and saves the cache for the dependencies which were completed. A
+clone which times out is removed too, other failures (e.g. `go get` failing to
+build what it downloaded) and directories which existed before are left alone.
+
+Each dependency is locked while it is installed, so several depman processes can
+install into the same GOPATH at once. Packages of the same repo share its lock.
+A process which finds a dependency locked
+prints a message and waits for up to `--lock-timeout` (5 minutes by default)
+before giving up on it, or forever if it is 0, repeating the message every
+minute. The lock files are kept in `$GOPATH/.depman-locks`.
+
+
+Offline Mode
+
+Running depman with `--offline` (or with the environment variable
+`DEPMAN_OFFLINE=1`) never accesses the network. The cache is ignored and every
+dependency is treated as fresh, nothing is cloned, fetched or updated, and the
+check for a new version of depman is skipped. Each dependency is checked out
+only if its version is already present locally, otherwise depman fails with a
+list of the dependencies which require network access (exit code 7).
+
+
+Mirrors
+
+With `--mirror` depman keeps one shared local mirror of each repo in
+`--mirror-dir` (`~/.depman/mirrors` by default). Clones and fetches first update
+the mirror from the network, then clone or fetch from the mirror locally, so
+projects and workspaces which share a dependency only download it once. Git
+clones from a mirror hard link its objects, so removing a mirror never breaks a
+checkout.
+
+Git, Mercurial and Bazaar repos are all cloned and fetched through their
+mirrors. For dependencies installed with `go get` the repo is cloned from its
+mirror before `go get` runs, so it only has to build it. This needs the url of
+the repo, which is known for github.com and bitbucket.org import paths and for
+paths with an element ending in `.git`, `.hg` or `.bzr` (e.g.
+`example.com/team/repo.git/pkg`). Other import paths are resolved by `go get`,
+which clones them from the network, and use the mirror from their first fetch
+onwards.
+
+`depman mirror gc [days]` removes the mirrors which have not been used in the
+given number of days (30 by default).
+
+Several depman processes can share the mirror directory. Each mirror is locked
+while it is updated or removed, and the index of mirrors (`index.json`) while it
+is written.
+
 
 Non Go-Getable Repos
 
@@ -121,6 +352,11 @@ supported by `go get`. To include those repositories in depman:
 
 3. Add an `alias` field to specify a directory in which to clone, the path is rooted at `$GOPATH/src/`
 
+Large `git-clone` repos can be cloned with a limited history by adding a
+`depth` field, and with only some directories checked out by adding a `sparse`
+field listing them. If the version is not in the limited history, depman
+fetches more history until it is.
+
 See the example below or the included `deps.json` file.
 
 
@@ -138,22 +374,177 @@ Depman uses a time based cache to speed up normal operations.
 
 A global list of dependencies and timestamps is kept at `$GOPATH/.depman.cache`.
 When depman is run it looks at the timestamp to decide whether to update the
-repo or not (`go get -u`, `git clone`, `git fetch`, etc).
-
-If the dependency is more that 1 hour old, depman will fetch updates from the
-network, otherwise depman uses the repo as is.
+repo or not (`go get -u`, `git clone`, `git fetch`, etc). Each entry also
+records the last revision fetched and the last error, if the install failed.
+Entries for dependencies which no longer exist on disk are removed
+automatically.
+
+Several depman processes can share a GOPATH. The cache file is locked while it
+is read and written, and each process only merges the entries it changed into
+the file. If the cache file is corrupt, depman warns, moves it to
+`$GOPATH/.depman.cache.corrupt` and starts with an empty cache.
+
+If the dependency is older than the cache ttl (1 hour by default), depman will
+fetch updates from the network, otherwise depman uses the repo as is. The ttl
+can be changed with the `--cache-ttl` flag or the `DEPMAN_CACHE_TTL`
+environment variable (e.g. `30m`, `4h`), and for a single dependency with the
+`cache-ttl` field in deps.json.
+
+Each dependency is cached by repo and install path, so the same repo installed
+in two parts of $GOPATH, or under two aliases, is fetched separately. Entries
+written by older versions of depman, which were cached by repo alone, are moved
+to the install path of the repo without an alias, or dropped if the repo is not
+installed there.
+
+The cache only matters for branches and tags. If the version is a commit hash
+which is already present in the local repo, depman never fetches it.
+
+When a git or mercurial dependency is fetched, only the branch, tag or commit
+named by its version is fetched. If that fails depman falls back to fetching
+everything.
 
 If the cache was stale or unused, a '*' will be printed at the end of the
 installation line.
 
+A dependency is only marked as fresh once it has been successfully fetched and
+updated, so failed or interrupted installs are retried on the next run.
+
 You can clear the cache by deleting the cache file, or running depman with the
 `--clear-cache` flag. The cache can be skipped for the current run by using the `--skip-cache` flag.
 
+`depman cache list` shows each entry with its age and whether it is stale.
+`depman cache evict` removes the entries for a repo or a nickname in deps.json,
+so they are fetched on the next install, and `depman cache touch` marks them as
+fresh.
+
 Additional information about the cache (including the time spent while
 installing) can been seen by running depman with the `--verbose` flag.
 
 The code for this feature is in the `timelock` package.
 
+Library
+
+Other Go programs can use depman through the `api` package, without its command
+line or flags:
+
+	opts := api.DefaultOptions()
+	opts.Jobs = 4
+	summary, err := api.Install(ctx, "path/to/project", opts)
+
+`api.Install`, `api.Freeze` and `api.Status` return their results and errors,
+they never exit the process. The summary returned by `api.Install` only holds
+the errors of that install. Cancelling the context stops an install as Ctrl-C
+would. The settings of depman are shared by the whole process, so only one call
+runs at a time.
+
+Plugins
+
+Other commands can be added without changing depman: when `foo` is not a built
+in command, `depman foo` runs the executable `depman-foo` found on PATH, passing
+it the remaining arguments, and exits with its exit code. Depman writes nothing
+before running a plugin, and does not check GOPATH, read the cache or check for
+a new version. `depman help` lists the plugins it finds. Besides the environment
+of depman (including GOPATH) the plugin receives:
+
+* `DEPMAN_DEPS_FILE`: the absolute path of deps.json, empty if there is none
+
+* `DEPMAN_TREE_FILE`: a temporary file holding a JSON array of the dependencies
+in deps.json, each with its `name`, `repo`, `version`, `type` and `path`, and for
+installed dependencies the `deps_file` and `deps` they declare. It is removed
+when the plugin exits
+
+* `DEPMAN_VERSION`: the version of depman
+
+Shell Completion
+
+Load the completion script for your shell, e.g. in ~/.bashrc:
+
+	source <(depman completion bash)
+
+or for zsh `source <(depman completion zsh)`, and for fish
+`depman completion fish | source`. Commands, sub commands and options are
+completed, as are the nicknames in deps.json for `update` and `cache`, and the
+branches and tags of an installed dependency for the second argument of
+`update`. The scripts run the hidden command `depman __complete`.
+
+Configuration
+
+Any option, except `--help`, `--version`, `--clear-cache` and `--path`, can be
+given a default in `~/.depmanrc`, in a `.depman.json` next to deps.json, or in
+an environment variable named `DEPMAN_` followed by the option in upper case
+with `_` for `-`. Both files hold a JSON object:
+
+	{
+		"verbose": true,
+		"skip-cache": true,
+		"cache-ttl": "30m",
+		"update-check": false
+	}
+
+and e.g. `DEPMAN_SKIP_CACHE=1` is the same as `--skip-cache`. The setting
+`update-check` (default true) has no flag, it controls whether depman checks
+for a new version on each run.
+
+Each source overrides the ones before it, from lowest to highest precedence:
+
+1. The built in defaults
+
+2. `~/.depmanrc`
+
+3. `.depman.json` in the directory of deps.json (see `--path`)
+
+4. `DEPMAN_*` environment variables
+
+5. The command line
+
+Options of a command only apply when running that command. An unknown option in
+either file is an error. `depman config show` lists the effective value of each
+option and where it was set. Colors disabled because the output is not a
+terminal, or by `NO_COLOR` or `FORCE_COLOR`, show that as the source of
+`no-colors`.
+
+Doctor
+
+`depman doctor` checks that the environment can install the dependencies in
+deps.json, and prints `ok`, `FAIL` with a suggested fix, or `skip` for each
+check:
+
+* each part of GOPATH is an absolute path to an existing directory, and the
+first part is writable
+
+* the programs needed by the types of the dependencies (go, git, hg, bzr) are on
+PATH, with their versions
+
+* the cache file can be parsed and written
+
+* the remote of each dependency answers `git ls-remote`, `hg identify` or
+`bzr revno`, without prompting for credentials (skipped with `--offline`)
+
+It exits with a non zero code if any check fails.
+
+Profiling
+
+`depman install --profile` records the time taken by each operation on each
+dependency: lock, clone, fetch, checkout, verify, update, and recurse (the
+installation of its own dependencies). At the end of the install it prints the
+10 slowest dependencies, with the time of each of their operations, and the 10
+slowest operations:
+
+	Profile: 13.250s in 3 operations on 2 dependencies
+	Slowest dependencies:
+	  12.000s  gocheck  fetch 12.000s
+	   1.250s  foo      clone 1.000s, checkout 0.250s
+	Slowest operations:
+	  12.000s  gocheck  fetch
+	   1.000s  foo      clone
+	   0.250s  foo      checkout
+
+`--profile-trace=trace.json` also writes every operation in the Chrome trace
+event format, with one row per dependency, which can be opened in
+chrome://tracing or https://ui.perfetto.dev to see where the time goes, e.g. in
+CI. Programs embedding depman set `Profile` and `ProfileTrace` in
+`api.Options`.
+
 Implementation Requirements
 
 * Depman shall not require any external dependencies (beyond the standard library) for normal operation
@@ -195,13 +586,16 @@ NOTE: The file must be named `deps.json`
 			"repo":"url/to/package, just like in import",
 			"version":"commit, tag, or branch",
 			"type": "one of 'git', 'bzr', 'hg'"
-			"skip-cache":"optional, set to 'true' to always ignore the cache"
+			"skip-cache":"optional, set to 'true' to always ignore the cache",
+			"cache-ttl":"optional, time before the cache expires for this dependency (e.g. '30m')"
 		},
 		"not go getable":{
 			"repo":"full git repo url, just like git clone"
 			"version":"commit, tag, or branch",
 			"type": "must be 'git-clone'",
-			"alias": "target directory to clone into, (only supported for type 'git-clone')"
+			"alias": "target directory to clone into, (only supported for type 'git-clone')",
+			"depth": "optional, clone only this many commits of history (only supported for type 'git-clone')",
+			"sparse": "optional, list of directories to check out (only supported for type 'git-clone')"
 		}
 	}
 
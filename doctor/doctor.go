@@ -0,0 +1,316 @@
+// Package doctor provides the doctor command, which checks that the environment can install the dependencies in a
+// deps.json: GOPATH, the version control systems, the cache file and access to each remote
+package doctor
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/mirror"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/timelock"
+	"github.com/vube/depman/util"
+)
+
+// ErrCheckFailed is registered for each check which fails
+var ErrCheckFailed = errors.New("check failed")
+
+// State is the outcome of a Check
+type State string
+
+// States of a Check
+const (
+	Pass State = "ok"
+	Fail State = "FAIL"
+	Skip State = "skip"
+)
+
+// Check is the outcome of a single diagnostic
+type Check struct {
+	State State `json:"state"`
+
+	// what was checked, e.g. a GOPATH part or a binary
+	Name string `json:"name"`
+
+	// the version found, or what went wrong
+	Detail string `json:"detail,omitempty"`
+
+	// how to fix a failure
+	Remedy string `json:"remedy,omitempty"`
+
+	// the kind of error registered for a failure
+	Kind result.Kind `json:"-"`
+}
+
+// Run checks the environment for deps, reporting each check to r and registering those which fail
+func Run(deps dep.DependencyMap, r report.Reporter) {
+	groups := []struct {
+		name   string
+		checks func() []Check
+	}{
+		{"GOPATH", GoPath},
+		{"Version control", func() []Check { return Binaries(deps) }},
+		{"Cache", func() []Check { return []Check{Cache()} }},
+		{"Remotes", func() []Check { return Remotes(deps) }},
+	}
+
+	for _, g := range groups {
+		r.Info(report.NoIndent, colors.Blue(g.name))
+		for _, c := range g.checks() {
+			r.Item(item(g.name, c))
+			if c.State == Fail {
+				result.Register(&result.Error{Kind: c.Kind, Op: "doctor", Command: c.Name, Output: c.Detail, Err: ErrCheckFailed})
+			}
+		}
+	}
+}
+
+// item returns the report of c, a check of group, with its remedy if it failed
+func item(group string, c Check) report.Item {
+	state := colors.Blue(string(Pass) + "  ")
+	switch c.State {
+	case Fail:
+		state = colors.Red(string(Fail))
+	case Skip:
+		state = colors.Yellow(string(Skip))
+	}
+
+	line := state + " " + c.Name
+	if c.Detail != "" {
+		line += ": " + c.Detail
+	}
+	lines := []string{line}
+
+	if c.State == Fail && c.Remedy != "" {
+		lines = append(lines, "     "+colors.Yellow("fix: "+c.Remedy))
+	}
+
+	return report.Item{
+		Event: report.EventCheck,
+		Value: struct {
+			Group string `json:"group"`
+			Check
+		}{group, c},
+		Lines: lines,
+	}
+}
+
+// GoPath checks that GOPATH is set and that each of its parts is an existing directory, the first part must be
+// writable since dependencies are installed there
+func GoPath() (checks []Check) {
+	goPath := os.Getenv("GOPATH")
+	if strings.TrimSpace(goPath) == "" {
+		return []Check{{State: Fail, Kind: result.Config, Name: "GOPATH", Detail: "not set",
+			Remedy: "export GOPATH=$HOME/go and add it to your shell profile"}}
+	}
+
+	for i, part := range strings.Split(goPath, ":") {
+		c := Check{State: Pass, Kind: result.Config, Name: part}
+
+		info, err := os.Stat(part)
+		switch {
+		case part == "" || !filepath.IsAbs(part):
+			c.State, c.Detail, c.Remedy = Fail, "not an absolute path", "use absolute paths in GOPATH, separated by ':'"
+		case os.IsNotExist(err):
+			c.State, c.Detail, c.Remedy = Fail, "does not exist", "mkdir -p "+part+", or remove it from GOPATH"
+		case err != nil:
+			c.State, c.Detail, c.Remedy = Fail, err.Error(), "check the permissions of "+part
+		case !info.IsDir():
+			c.State, c.Detail, c.Remedy = Fail, "not a directory", "remove it from GOPATH"
+		case i == 0:
+			if err = writable(part); err != nil {
+				c.State, c.Detail, c.Remedy = Fail, "not writable: "+err.Error(), "check the permissions of "+part+", dependencies are installed there"
+			}
+		}
+		checks = append(checks, c)
+	}
+	return
+}
+
+// writable returns an error if a file can't be created in dir
+func writable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".depman-doctor")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// binaries returns the programs needed to install each type of dependency
+var binaries = map[string][]string{
+	dep.TypeGit:      {"go", "git"},
+	dep.TypeGitClone: {"git"},
+	dep.TypeHg:       {"go", "hg"},
+	dep.TypeBzr:      {"go", "bzr"},
+}
+
+// the arguments displaying the version of each binary
+var versionArgs = map[string][]string{
+	"go":  {"version"},
+	"git": {"--version"},
+	"hg":  {"--version", "--quiet"},
+	"bzr": {"--version"},
+}
+
+// Binaries checks that the programs needed by deps are on PATH, and reports their versions
+func Binaries(deps dep.DependencyMap) (checks []Check) {
+	needed := make(map[string]bool)
+	for _, d := range deps.Map {
+		for _, b := range binaries[d.Type] {
+			needed[b] = true
+		}
+	}
+
+	var names []string
+	for b := range needed {
+		names = append(names, b)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return []Check{{State: Skip, Name: "no dependencies in " + dep.DepsFile}}
+	}
+
+	l := quietLogger()
+	for _, b := range names {
+		c := Check{State: Pass, Kind: result.Config, Name: b}
+
+		path, err := exec.LookPath(b)
+		if err != nil {
+			c.State, c.Detail, c.Remedy = Fail, "not found on PATH", "install "+b+" with your package manager, or add its directory to PATH"
+		} else {
+			out, err := l.Capture("", append([]string{path}, versionArgs[b]...)...)
+			c.Detail = firstLine(out)
+			if err != nil {
+				c.State, c.Remedy = Fail, "reinstall "+b
+			}
+		}
+		checks = append(checks, c)
+	}
+	return
+}
+
+// Cache checks that the cache file can be parsed and written
+func Cache() Check {
+	if strings.TrimSpace(os.Getenv("GOPATH")) == "" {
+		return Check{State: Skip, Name: "the cache file is in GOPATH, which is not set"}
+	}
+
+	file, err := timelock.Check()
+	if err != nil {
+		return Check{State: Fail, Kind: result.Config, Name: file, Detail: err.Error(),
+			Remedy: "check the permissions of " + file + ", or clear it with 'depman --clear-cache'"}
+	}
+	return Check{State: Pass, Name: file}
+}
+
+// Remotes checks that the remote of each dependency can be reached, unless depman is offline
+func Remotes(deps dep.DependencyMap) (checks []Check) {
+	var names []string
+	for name := range deps.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	l := quietLogger()
+	for _, name := range names {
+		d := deps.Map[name]
+		c := Check{State: Pass, Kind: result.VCS, Name: name}
+
+		url := remoteURL(l, d)
+		args := probe(d, url)
+
+		switch {
+		case util.Offline():
+			c.State, c.Detail = Skip, "offline"
+		case args == nil:
+			c.State, c.Detail = Skip, "unknown type "+d.Type
+		default:
+			c.Detail = url
+			if _, err := exec.LookPath(args[0]); err != nil {
+				c.State, c.Detail = Skip, args[0]+" not found on PATH"
+				break
+			}
+
+			out, err := l.Capture("", args...)
+			if err != nil {
+				c.State = Fail
+				c.Detail = url + ": " + firstLine(out)
+				c.Remedy = remedy(url)
+			}
+		}
+		checks = append(checks, c)
+	}
+	return
+}
+
+// remoteURL returns the url of the remote of d, the one configured in its clone if it is installed
+func remoteURL(l *util.Logger, d *dep.Dependency) (url string) {
+	if util.Exists(d.Path()) {
+		switch d.Type {
+		case dep.TypeGit, dep.TypeGitClone:
+			url, _ = l.Capture(d.Path(), "git", "config", "--get", "remote.origin.url")
+		case dep.TypeHg:
+			url, _ = l.Capture(d.Path(), "hg", "paths", "default")
+		case dep.TypeBzr:
+			url, _ = l.Capture(d.Path(), "bzr", "config", "parent_location")
+		}
+		if url != "" {
+			return
+		}
+	}
+
+	// go get resolves import paths, which usually live at https://
+	if d.Type == dep.TypeGitClone || strings.Contains(d.Repo, "://") {
+		return d.Repo
+	}
+
+	// the rest of the import path may be a package inside the repo
+	if _, url, ok := mirror.RepoRoot(d.Repo); ok {
+		return url
+	}
+	return "https://" + d.Repo
+}
+
+// probe returns the command which checks that the remote url of d can be reached, nil for an unknown type
+func probe(d *dep.Dependency, url string) []string {
+	switch d.Type {
+	case dep.TypeGit, dep.TypeGitClone:
+		return []string{"git", "ls-remote", url, "HEAD"}
+	case dep.TypeHg:
+		return []string{"hg", "identify", url}
+	case dep.TypeBzr:
+		return []string{"bzr", "revno", url}
+	}
+	return nil
+}
+
+// remedy returns how to fix access to the remote url
+func remedy(url string) string {
+	if strings.HasPrefix(url, "ssh://") || (strings.Contains(url, "@") && !strings.Contains(url, "://")) {
+		return "check that an SSH key with access to the repo is loaded (ssh-add -l) and that the host is in ~/.ssh/known_hosts"
+	}
+	return "check the url and your network connection, and for a private repo your credentials (e.g. a git credential helper)"
+}
+
+// quietLogger returns a Logger for commands whose output is only used to build checks
+func quietLogger() *util.Logger {
+	return util.NewLogger(report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard), false, 0)
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	return strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+}
@@ -0,0 +1,180 @@
+package doctor
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/util"
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func TestDoctor(t *testing.T) {
+	TestingT(t)
+}
+
+type DoctorSuite struct {
+	dir     string
+	restore func()
+	path    string
+	buf     *bytes.Buffer
+}
+
+var _ = Suite(&DoctorSuite{})
+
+func (s *DoctorSuite) SetUpTest(c *C) {
+	colors.Mock()
+	s.buf = new(bytes.Buffer)
+	util.Mock(s.buf)
+
+	s.dir, s.restore = fixture.GoPath(c)
+	s.path = os.Getenv("PATH")
+}
+
+func (s *DoctorSuite) TearDownTest(c *C) {
+	os.Setenv("PATH", s.path)
+	s.restore()
+	util.SetOffline(false)
+	result.Reset()
+}
+
+func (s *DoctorSuite) TestGoPath(c *C) {
+	file := filepath.Join(s.dir, "file")
+	c.Assert(ioutil.WriteFile(file, nil, 0644), IsNil)
+
+	os.Setenv("GOPATH", strings.Join([]string{s.dir, "relative", filepath.Join(s.dir, "missing"), file}, ":"))
+	checks := GoPath()
+	c.Assert(checks, HasLen, 4)
+	c.Check(checks[0].State, Equals, Pass)
+	c.Check(checks[1].Detail, Equals, "not an absolute path")
+	c.Check(checks[2].Detail, Equals, "does not exist")
+	c.Check(checks[2].Remedy, Equals, "mkdir -p "+filepath.Join(s.dir, "missing")+", or remove it from GOPATH")
+	c.Check(checks[3].Detail, Equals, "not a directory")
+
+	os.Setenv("GOPATH", "")
+	checks = GoPath()
+	c.Assert(checks, HasLen, 1)
+	c.Check(checks[0].State, Equals, Fail)
+	c.Check(checks[0].Detail, Equals, "not set")
+}
+
+func (s *DoctorSuite) TestBinaries(c *C) {
+	git, err := exec.LookPath("git")
+	c.Assert(err, IsNil)
+
+	// only git is on PATH
+	bin := filepath.Join(s.dir, "bin")
+	c.Assert(os.Mkdir(bin, 0755), IsNil)
+	c.Assert(os.Symlink(git, filepath.Join(bin, "git")), IsNil)
+	os.Setenv("PATH", bin)
+
+	deps := dep.New()
+	deps.Map["one"] = &dep.Dependency{Repo: "https://example.com/one.git", Type: dep.TypeGitClone}
+	deps.Map["two"] = &dep.Dependency{Repo: "example.com/two", Type: dep.TypeHg}
+
+	checks := Binaries(deps)
+	c.Assert(checks, HasLen, 3)
+	c.Check(checks[0].Name, Equals, "git")
+	c.Check(checks[0].State, Equals, Pass)
+	c.Check(checks[0].Detail, Matches, "git version .*")
+	c.Check(checks[1].Name, Equals, "go")
+	c.Check(checks[1].State, Equals, Fail)
+	c.Check(checks[2].Name, Equals, "hg")
+	c.Check(checks[2].Detail, Equals, "not found on PATH")
+
+	c.Check(Binaries(dep.New())[0].State, Equals, Skip)
+}
+
+func (s *DoctorSuite) TestCache(c *C) {
+	c.Check(Cache(), Equals, Check{State: Pass, Name: filepath.Join(s.dir, ".depman.cache")})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, ".depman.cache"), []byte("{"), 0644), IsNil)
+	check := Cache()
+	c.Check(check.State, Equals, Fail)
+	c.Check(check.Detail, Matches, "is corrupt: .*")
+}
+
+func (s *DoctorSuite) TestRemotes(c *C) {
+	origin := filepath.Join(s.dir, "origin")
+	c.Assert(os.Mkdir(origin, 0755), IsNil)
+	fixture.Git(c, origin, "init", "-q")
+
+	deps := dep.New()
+	deps.Map["good"] = &dep.Dependency{Repo: origin, Type: dep.TypeGitClone, Alias: "good"}
+	deps.Map["bad"] = &dep.Dependency{Repo: filepath.Join(s.dir, "missing"), Type: dep.TypeGitClone, Alias: "bad"}
+
+	checks := Remotes(deps)
+	c.Assert(checks, HasLen, 2)
+	c.Check(checks[0].Name, Equals, "bad")
+	c.Check(checks[0].State, Equals, Fail)
+	c.Check(checks[1].Name, Equals, "good")
+	c.Check(checks[1].State, Equals, Pass)
+	c.Check(checks[1].Detail, Equals, origin)
+
+	util.SetOffline(true)
+	c.Check(Remotes(deps)[0].State, Equals, Skip)
+}
+
+func (s *DoctorSuite) TestRemoteURL(c *C) {
+	l := quietLogger()
+	c.Check(remoteURL(l, &dep.Dependency{Repo: "github.com/vube/gocov/gocov", Type: dep.TypeGit}), Equals, "https://github.com/vube/gocov")
+	c.Check(remoteURL(l, &dep.Dependency{Repo: "example.com/a/b/c", Type: dep.TypeHg}), Equals, "https://example.com/a/b/c")
+	c.Check(remoteURL(l, &dep.Dependency{Repo: "git@example.com:a.git", Type: dep.TypeGitClone, Alias: "a"}), Equals, "git@example.com:a.git")
+}
+
+func (s *DoctorSuite) TestRemedy(c *C) {
+	c.Check(remedy("git@github.com:vube/private.git"), Matches, ".*SSH key.*")
+	c.Check(remedy("ssh://git@github.com/vube/private.git"), Matches, ".*SSH key.*")
+	c.Check(remedy("https://user@example.com/repo.git"), Matches, ".*credentials.*")
+}
+
+func (s *DoctorSuite) TestRun(c *C) {
+	os.Setenv("GOPATH", filepath.Join(s.dir, "missing"))
+	Run(dep.New(), report.NewText(log.New(s.buf, "", 0), s.buf))
+
+	c.Check(result.ExitCode(), Equals, int(result.Config))
+	c.Check(s.buf.String(), Matches, "(?s)GOPATH\n \\| FAIL .*/missing: does not exist\n \\|      fix: mkdir -p .*\nVersion control\n \\| skip no dependencies in deps.json\n.*")
+}
+
+func (s *DoctorSuite) TestRunJSON(c *C) {
+	os.Setenv("GOPATH", filepath.Join(s.dir, "missing"))
+
+	var events bytes.Buffer
+	Run(dep.New(), report.NewJSON(&events, report.NewText(log.New(s.buf, "", 0), s.buf)))
+
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	c.Assert(len(lines) > 2, Equals, true)
+
+	var check struct {
+		Event  string `json:"event"`
+		Group  string `json:"group"`
+		State  State  `json:"state"`
+		Name   string `json:"name"`
+		Detail string `json:"detail"`
+		Remedy string `json:"remedy"`
+	}
+	c.Assert(json.Unmarshal([]byte(lines[0]), &check), IsNil)
+	c.Check(check.Event, Equals, report.EventCheck)
+	c.Check(check.Group, Equals, "GOPATH")
+	c.Check(check.State, Equals, Fail)
+	c.Check(check.Name, Equals, filepath.Join(s.dir, "missing"))
+	c.Check(check.Detail, Equals, "does not exist")
+	c.Check(check.Remedy, Matches, "mkdir -p .*")
+
+	// only the headings of the groups are displayed
+	c.Check(s.buf.String(), Matches, "GOPATH\nVersion control\nCache\nRemotes\n")
+}
@@ -0,0 +1,37 @@
+// Package fixture sets up the temporary GOPATHs and git repositories used by the tests of depman
+package fixture
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+// GoPath points GOPATH at a new temporary directory, restore points it back and removes the directory
+func GoPath(c *C) (dir string, restore func()) {
+	dir, err := ioutil.TempDir("", "DepmanUnitTest")
+	c.Assert(err, IsNil)
+
+	gopath := os.Getenv("GOPATH")
+	os.Setenv("GOPATH", dir)
+
+	restore = func() {
+		os.Setenv("GOPATH", gopath)
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+// Git runs git in dir with an identity to commit as and returns its trimmed output, the test fails if git does
+func Git(c *C, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=depman", "-c", "user.email=depman@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+	return strings.TrimSpace(string(out))
+}
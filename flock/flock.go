@@ -0,0 +1,79 @@
+// Package flock provides advisory file locks, used to coordinate several depman processes sharing a GOPATH
+package flock
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/vube/depman/util"
+)
+
+// how often a held lock is retried
+const pollInterval = 100 * time.Millisecond
+
+// how often waiting is called again while the lock is held
+var remindInterval = time.Minute
+
+// ErrTimeout is returned when a lock could not be acquired in time
+var ErrTimeout = errors.New("timed out waiting for lock")
+
+// errLocked is returned by lock when the file is locked by another process
+var errLocked = errors.New("locked")
+
+// Lock is an advisory lock on a file
+type Lock struct {
+	f *os.File
+}
+
+// Acquire locks the file at path, creating it if necessary.
+// If the lock is held by another process, waiting is called (if it is not nil) with the time waited so far, first
+// with 0 and then every minute, and Acquire retries until the lock is released, timeout expires (a timeout of 0
+// waits forever) or depman is interrupted
+func Acquire(path string, timeout time.Duration, waiting func(waited time.Duration)) (l *Lock, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	var remind time.Time
+	for {
+		err = lock(f)
+		if err == nil {
+			return &Lock{f: f}, nil
+		}
+
+		if err != errLocked {
+			f.Close()
+			return
+		}
+
+		if waiting != nil && !time.Now().Before(remind) {
+			waiting(time.Since(start) / time.Second * time.Second)
+			remind = time.Now().Add(remindInterval)
+		}
+
+		switch {
+		case util.Interrupted():
+			err = util.ErrInterrupted
+		case timeout > 0 && time.Since(start) >= timeout:
+			err = ErrTimeout
+		default:
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		f.Close()
+		return
+	}
+}
+
+// Release unlocks the file, the lock file itself is left in place
+func (l *Lock) Release() (err error) {
+	err = unlock(l.f)
+	l.f.Close()
+	return
+}
@@ -0,0 +1,52 @@
+package flock
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func TestFlock(t *testing.T) {
+	TestingT(t)
+}
+
+type FlockSuite struct{}
+
+var _ = Suite(&FlockSuite{})
+
+func (s *FlockSuite) TestAcquire(c *C) {
+	dir, err := ioutil.TempDir("", "DepmanUnitTest")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lock")
+
+	l, err := Acquire(path, 0, nil)
+	c.Assert(err, IsNil)
+
+	var waited []time.Duration
+	_, err = Acquire(path, 200*time.Millisecond, func(d time.Duration) { waited = append(waited, d) })
+	c.Check(err, Equals, ErrTimeout)
+	c.Check(waited, DeepEquals, []time.Duration{0})
+
+	// reminded while waiting
+	remindInterval = 0
+	defer func() { remindInterval = time.Minute }()
+	waited = nil
+	_, err = Acquire(path, 250*time.Millisecond, func(d time.Duration) { waited = append(waited, d) })
+	c.Check(err, Equals, ErrTimeout)
+	c.Check(len(waited) > 1, Equals, true)
+
+	c.Assert(l.Release(), IsNil)
+
+	l, err = Acquire(path, 200*time.Millisecond, nil)
+	c.Assert(err, IsNil)
+	c.Check(l.Release(), IsNil)
+}
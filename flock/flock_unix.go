@@ -0,0 +1,24 @@
+// +build !windows
+
+package flock
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"os"
+	"syscall"
+)
+
+// lock takes an exclusive lock on f without blocking, it returns errLocked if another process holds it
+func lock(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+// unlock releases the lock on f
+func unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,48 @@
+package flock
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	// flags of LockFileEx
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	// returned by LockFileEx when another process holds the lock
+	errorLockViolation syscall.Errno = 33
+)
+
+// lock takes an exclusive lock on the first byte of f without blocking, it returns errLocked if another process
+// holds it
+func lock(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLocked
+	}
+	return err
+}
+
+// unlock releases the lock on f
+func unlock(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	return err
+}
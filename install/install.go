@@ -1,16 +1,31 @@
 // Package install provides functions to recursively install dependencies
-// Cleaning of existing changes in dependency repositories is controlled by the --clean flag
+// Cleaning of existing changes in dependency repositories is controlled by SetClean (--clean)
+// The number of dependencies installed in parallel is controlled by SetJobs (--jobs)
+// Each dependency is locked while it is installed, so concurrent runs of depman wait for each other for up to
+// SetLockTimeout (--lock-timeout)
+// With --offline only versions already present locally are checked out, nothing is cloned, fetched or updated
+// Progress is described to the report.Reporter passed to Install, the time taken by each operation is recorded by
+// package profile
 package install
 
 // Copyright 2013-2014 Vubeology, Inc.
 
 import (
-	"flag"
+	"crypto/sha1"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/vube/depman/colors"
 	"github.com/vube/depman/dep"
+	"github.com/vube/depman/flock"
+	"github.com/vube/depman/profile"
+	"github.com/vube/depman/report"
 	"github.com/vube/depman/result"
 	"github.com/vube/depman/timelock"
 	"github.com/vube/depman/util"
@@ -18,98 +33,394 @@ import (
 
 var (
 	clean bool
+
+	// number of dependencies to install in parallel
+	jobs = 1
+
+	// how long to wait for another depman process to finish with a dependency
+	lockTimeout = 5 * time.Minute
 )
 
 // Whether to install recursively
 var Recurse = true
 
-func init() {
-	flag.BoolVar(&clean, "clean", false, "Remove changes to code in dependencies")
+// ErrOffline indicates that some dependencies could not be installed without network access
+var ErrOffline = errors.New("some dependencies require network access")
+
+var (
+	// dependencies which could not be installed because of --offline, as "name (version) repo: reason"
+	missing []string
+
+	// guards missing
+	missingLock sync.Mutex
+)
+
+// SetClean sets whether to remove changes to code in dependencies
+func SetClean(c bool) {
+	clean = c
 }
 
-// Install a DependencyMap
-func Install(deps dep.DependencyMap) error {
-	util.Print(colors.Blue("Installing:"))
-	set := make(map[string]string)
-	return recursiveInstall(deps, set)
+// SetJobs sets the number of dependencies to install in parallel
+func SetJobs(n int) {
+	jobs = n
 }
 
-// recursively install a DependencyMap
-func recursiveInstall(deps dep.DependencyMap, set map[string]string) (err error) {
-	for name, d := range deps.Map {
-		start := time.Now()
+// SetLockTimeout sets the time to wait for another depman process to finish with a dependency, 0 waits forever
+func SetLockTimeout(t time.Duration) {
+	lockTimeout = t
+}
 
-		if duplicate(*d, set) {
-			continue
+// job is a single dependency to be installed
+type job struct {
+	name string
+	d    *dep.Dependency
+	err  error
+}
+
+// Install a DependencyMap, reporting progress to r
+// Returns ErrInterrupted or ErrOffline, otherwise the result.Errors of the dependencies which failed to install
+func Install(deps dep.DependencyMap, r report.Reporter) error {
+	r.Info(report.NoIndent, colors.Blue("Installing:"))
+	set := make(map[string]string)
+	missing = nil
+
+	errs := recursiveInstall(deps, set, r, 0)
+
+	if util.Interrupted() {
+		return util.ErrInterrupted
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		r.Info(report.NoIndent, colors.Red("Offline: the following dependencies require network access:"))
+		for _, m := range missing {
+			r.Info(report.NoIndent, colors.Red("    "+m))
 		}
+		return ErrOffline
+	}
 
-		stale := timelock.IsStale(d)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
 
-		util.PrintDep(name, d.Version, d.Repo, stale)
+// recursively install a DependencyMap
+// Duplicates are checked in name order before anything is installed so the result does not depend on scheduling,
+// then the dependencies are installed using up to --jobs workers, finally each dependency's output is flushed
+// and its own dependencies are installed, again in name order.
+// The errors of the dependencies which failed are returned, annotated with the deps.json declaring them
+func recursiveInstall(deps dep.DependencyMap, set map[string]string, r report.Reporter, depth int) (errs result.Errors) {
+	var names []string
+	for name := range deps.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		subPath := d.Path()
-		err = d.VCS.Clone(d)
+	var todo []*job
+	for _, name := range names {
+		d := deps.Map[name]
+		skip, err := duplicate(*d, set, r)
 		if err != nil {
+			// installing either version would break the dependents of the other, so stop here
+			e := err.(*result.Error)
+			e.DepsFile = deps.Path
+			errs = append(errs, e)
+			return
+		}
+		if skip {
+			r.Skipped(report.Dependency{Name: name, Repo: d.Repo, Version: d.Version, Depth: depth})
 			continue
 		}
+		d.Log = util.NewLogger(r, jobs > 1, depth)
+		todo = append(todo, &job{name: name, d: d})
+	}
+	r.Queued(len(todo))
 
-		util.Cd(subPath)
+	if jobs > 1 {
+		runJobs(todo, jobs)
+	}
 
-		if clean {
-			d.VCS.Clean(d)
+	for _, j := range todo {
+		if jobs <= 1 && !util.Interrupted() {
+			j.err = installOne(j.name, j.d)
 		}
+		j.d.Log.Flush()
 
-		if stale {
-			util.VerboseIndent("# repo is stale, fetching")
-			err = d.VCS.Fetch(d)
-			if err != nil {
-				continue
-			}
+		if e, ok := j.err.(*result.Error); ok {
+			e.DepsFile = deps.Path
+			errs = append(errs, e)
 		}
 
-		err = d.VCS.Checkout(d)
-		if err != nil {
+		// after an interrupt only the output of the completed jobs is flushed
+		if j.err != nil || util.Interrupted() {
 			continue
 		}
 
-		if stale {
-			err = d.VCS.Update(d)
+		// Recursive
+		depsFile := util.UpwardFind(j.d.Path(), dep.DepsFile)
+		if depsFile != "" && Recurse {
+			subDeps, err := dep.Read(depsFile, r)
 			if err != nil {
-				continue
+				e := &result.Error{Dep: j.name, Op: "read", DepsFile: depsFile, Err: err}
+				result.Register(e)
+				r.Error(report.Dependency{}, e)
+				errs = append(errs, e)
+			} else {
+				t := profile.Start(j.name, j.d.Repo)
+				t.Op(profile.OpRecurse)
+				sub := recursiveInstall(subDeps, set, r, depth+1)
+				t.Stop()
+				errs = append(errs, sub...)
+				if conflicted(sub) {
+					return
+				}
 			}
 		}
+	}
 
-		util.VerboseIndent(fmt.Sprintf("# time to install: %.3fs", time.Since(start).Seconds()))
+	return
+}
 
-		// Recursive
-		depsFile := util.UpwardFind(subPath, dep.DepsFile)
-		if depsFile != "" && Recurse {
-			subDeps, err := dep.Read(depsFile)
-			if err != nil {
-				util.Print(colors.Red("Error reading deps from '" + depsFile + "': " + err.Error()))
-				result.RegisterError()
-			} else {
-				util.IncreaseIndent()
-				recursiveInstall(subDeps, set)
-				util.DecreaseIndent()
+// conflicted returns true if errs include a Conflict, which stops the install
+func conflicted(errs result.Errors) bool {
+	for _, e := range errs {
+		if e.Kind == result.Conflict {
+			return true
+		}
+	}
+	return false
+}
+
+// runJobs installs each job using n workers, and waits for them all to complete
+func runJobs(todo []*job, n int) {
+	var wg sync.WaitGroup
+	queue := make(chan *job)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				if util.Interrupted() {
+					j.err = util.ErrInterrupted
+					continue
+				}
+				j.err = installOne(j.name, j.d)
 			}
+		}()
+	}
+
+	for _, j := range todo {
+		queue <- j
+	}
+	close(queue)
+
+	wg.Wait()
+}
+
+// installOne clones, fetches and checks out a single dependency, writing output to d.Log
+// Errors are returned as a *result.Error annotated with the dependency and the operation which failed
+func installOne(name string, d *dep.Dependency) (err error) {
+	// the operation in progress
+	op := "lock"
+	defer func() {
+		if err == nil || err == util.ErrInterrupted {
+			return
+		}
+
+		e, ok := err.(*result.Error)
+		if !ok {
+			e = &result.Error{Err: err}
+			result.Register(e)
+		}
+		e.Dep = name
+		e.Op = op
+		d.Log.Error(e)
+
+		// a dependency which is missing offline is not a failure of the cache entry
+		if !util.Offline() {
+			timelock.Failed(d, op+": "+e.Reason())
 		}
+		err = e
+	}()
+
+	// the commands checking whether d is pinned are about d, before it is reported as started
+	d.Log.Subject(name, d.Version, d.Repo)
+
+	t := profile.Start(name, d.Repo)
+	defer t.Stop()
+
+	// another process may be installing d, whether it is stale or pinned is only known once it has finished
+	t.Op(op)
+	l, err := lock(d)
+	if err != nil {
+		d.Log.PrintDep(name, d.Version, d.Repo, false)
+		err = fmt.Errorf("could not lock %s: %s", d.RootPath(), err)
+		return
+	}
+	defer l.Release()
+
+	stale := timelock.IsStale(d) && !util.Offline()
+
+	// a commit which is already present can't change, so only branches and tags need fetching
+	pinned := stale && util.Exists(d.Path()) && d.VCS.IsPinned(d)
+	if pinned {
+		stale = false
+	}
+
+	d.Log.PrintDep(name, d.Version, d.Repo, stale)
+
+	if pinned {
+		d.Log.VerboseIndent("# pinned revision is present locally, skipping fetch")
+	}
+
+	if util.Offline() {
+		op = "checkout"
+		t.Op(op)
+		err = installOffline(name, d)
+		if err == nil {
+			d.Log.CheckedOut(d.VCS.Revision(d))
+		}
+		return
+	}
+
+	op = "clone"
+	t.Op(op)
+	err = d.VCS.Clone(d)
+	if err != nil {
+		return
 	}
+
+	if clean {
+		d.VCS.Clean(d)
+	}
+
+	if stale {
+		d.Log.VerboseIndent("# repo is stale, fetching")
+		op = "fetch"
+		t.Op(op)
+		err = d.VCS.Fetch(d)
+		if err != nil {
+			return
+		}
+		d.Log.Fetched()
+	}
+
+	op = "checkout"
+	t.Op(op)
+	err = d.VCS.Checkout(d)
+	if err != nil {
+		return
+	}
+
+	op = "verify"
+	t.Op(op)
+	err = verify(d)
+	if err != nil {
+		return
+	}
+
+	if stale {
+		op = "update"
+		t.Op(op)
+		err = d.VCS.Update(d)
+		if err != nil {
+			return
+		}
+	}
+
+	head := d.VCS.Revision(d)
+	if stale {
+		timelock.Touch(d, head)
+	}
+	d.Log.CheckedOut(head)
 	return
 }
 
+// lock acquires the lock file for the repo holding d, printing a message if another process holds it. Packages of the
+// same repo share its lock, as installing one updates the others.
+// Lock files are kept in $GOPATH/.depman-locks rather than in the dependency, where they would show up as changes
+func lock(d *dep.Dependency) (l *flock.Lock, err error) {
+	parts := strings.Split(os.Getenv("GOPATH"), ":")
+	dir := filepath.Join(parts[0], ".depman-locks")
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return
+	}
+
+	root := d.RootPath()
+	path := filepath.Join(dir, fmt.Sprintf("%x", sha1.Sum([]byte(root))))
+
+	return flock.Acquire(path, lockTimeout, func(waited time.Duration) {
+		// reported immediately, even if the output of d is buffered
+		if waited == 0 {
+			d.Log.Notice("Waiting for another depman process to finish with " + root)
+		} else {
+			d.Log.Notice("Still waiting after " + waited.String() + " for another depman process to finish with " + root)
+		}
+	})
+}
+
+// verify checks that a dependency pinned to a commit has that commit checked out
+func verify(d *dep.Dependency) (err error) {
+	if !d.VCS.IsPinned(d) {
+		return
+	}
+
+	// mercurial marks a working copy with local changes with a trailing '+'
+	rev := strings.TrimSuffix(d.VCS.Revision(d), "+")
+	if rev == "" || strings.HasPrefix(rev, d.Version) || strings.HasPrefix(d.Version, rev) {
+		return
+	}
+
+	e := &result.Error{Kind: result.Verify, Err: fmt.Errorf("checked out %s instead of the pinned revision %s", rev, d.Version)}
+	result.Register(e)
+	return e
+}
+
+// installOffline checks out a dependency which is already present locally, without using the network
+func installOffline(name string, d *dep.Dependency) (err error) {
+	var reason string
+
+	if !util.Exists(d.Path()) {
+		reason = "not cloned"
+	} else if !d.VCS.HasVersion(d) {
+		reason = "version not present locally"
+	}
+
+	if reason != "" {
+		missingLock.Lock()
+		missing = append(missing, name+" ("+d.Version+") "+d.Repo+": "+reason)
+		missingLock.Unlock()
+
+		e := &result.Error{Kind: result.Offline, Err: errors.New("requires network access: " + reason)}
+		result.Register(e)
+		return e
+	}
+
+	if clean {
+		d.VCS.Clean(d)
+	}
+
+	return d.VCS.Checkout(d)
+}
+
 // Check for duplicate dependency
 // if same name and same version, skip
-// if same name and different version, exit
+// if same name and different version, return a Conflict error
 // if different name, add to set, don't skip
-func duplicate(d dep.Dependency, set map[string]string) (skip bool) {
+func duplicate(d dep.Dependency, set map[string]string, r report.Reporter) (skip bool, err error) {
 	version, installed := set[d.Repo]
 	if installed && version != d.Version {
-		util.Print(colors.Red("ERROR    : Duplicate dependency with different versions detected"))
-		util.Print(colors.Red("Repo     : " + d.Repo))
-		util.Fatal(colors.Red("Versions : " + d.Version + "\t" + version))
+		e := &result.Error{Kind: result.Conflict, Dep: d.Repo, Op: "install", Err: fmt.Errorf("required at versions %s and %s", d.Version, version)}
+		result.Register(e)
+		r.Info(report.NoIndent, colors.Red("ERROR    : Duplicate dependency with different versions detected"))
+		r.Info(report.NoIndent, colors.Red("Repo     : "+d.Repo))
+		r.Info(report.NoIndent, colors.Red("Versions : "+d.Version+"\t"+version))
+		err = e
 	} else if installed {
-		util.VerboseIndent(colors.Yellow("Skipping previously installed dependency: ") + d.Repo)
 		skip = true
 	} else {
 		set[d.Repo] = d.Version
@@ -4,13 +4,23 @@ package install
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	. "launchpad.net/gocheck"
 
 	"github.com/vube/depman/colors"
 	"github.com/vube/depman/dep"
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
 	"github.com/vube/depman/util"
 )
 
@@ -32,8 +42,42 @@ func (s *TestSuite) SetUpTest(c *C) {
 	util.Mock(s.buf)
 	log.SetFlags(0)
 	clean = false
+	jobs = 1
 }
 
+// fakeVCS records the operations run on each dependency, failing the ones listed in fail
+type fakeVCS struct {
+	lock sync.Mutex
+	ops  []string
+	fail map[string]bool
+	rev  string
+}
+
+func (f *fakeVCS) record(op string, d *dep.Dependency) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.ops = append(f.ops, op+" "+d.Repo)
+	if f.fail[op+" "+d.Repo] {
+		return &result.Error{Command: op, Output: "fatal: " + op + " failed", Err: errors.New("exit status 1")}
+	}
+	return nil
+}
+
+func (f *fakeVCS) Clone(d *dep.Dependency) error    { return f.record("clone", d) }
+func (f *fakeVCS) Fetch(d *dep.Dependency) error    { return f.record("fetch", d) }
+func (f *fakeVCS) Update(d *dep.Dependency) error   { return f.record("update", d) }
+func (f *fakeVCS) Checkout(d *dep.Dependency) error { return f.record("checkout", d) }
+func (f *fakeVCS) Clean(d *dep.Dependency)          { f.record("clean", d) }
+
+func (f *fakeVCS) HasVersion(d *dep.Dependency) bool { return d.Version == "local" }
+func (f *fakeVCS) IsPinned(d *dep.Dependency) bool   { return d.Version == "local" }
+func (f *fakeVCS) Revision(d *dep.Dependency) string { return f.rev }
+
+func (f *fakeVCS) Versions(d *dep.Dependency) ([]string, error) { return nil, nil }
+
+func (f *fakeVCS) LastCommit(d *dep.Dependency, branch string) (string, error) { return "", nil }
+func (f *fakeVCS) GetHead(d *dep.Dependency) (string, error)                   { return "", nil }
+
 /*
 func (s *TestSuite) TestGitClone(c *C) {
 	var cmds []string
@@ -68,13 +112,15 @@ func (s *TestSuite) TestGitClone(c *C) {
 */
 
 func (s *TestSuite) TestDuplicate(c *C) {
+	defer result.Reset()
 	set := make(map[string]string)
 
 	d := dep.Dependency{Repo: "repo", Version: "version", Type: "type"}
 	c.Check(len(set), Equals, 0)
 
 	// No dup
-	skip := duplicate(d, set)
+	skip, err := duplicate(d, set, util.NewReporter())
+	c.Check(err, IsNil)
 	c.Check(skip, Equals, false)
 	c.Check(len(set), Equals, 1)
 	v, ok := set["repo"]
@@ -83,19 +129,215 @@ func (s *TestSuite) TestDuplicate(c *C) {
 
 	// dup same version
 	util.SetVerbose(true)
-	skip = duplicate(d, set)
+	skip, err = duplicate(d, set, util.NewReporter())
+	c.Check(err, IsNil)
 	c.Check(len(set), Equals, 1)
-	c.Check(s.buf.String(), Equals, "Skipping previously installed dependency: repo\n")
 	c.Check(skip, Equals, true)
 
 	s.buf.Truncate(0)
 
 	// dup different version
 	d.Version = "version2"
-	skip = duplicate(d, set)
+	skip, err = duplicate(d, set, util.NewReporter())
+	c.Check(err.(*result.Error).Kind, Equals, result.Conflict)
 	c.Check(len(set), Equals, 1)
 	out := "ERROR    : Duplicate dependency with different versions detected\nRepo     : repo\nVersions : version2\tversion\n"
 	c.Check(s.buf.String(), Equals, out)
 	c.Check(skip, Equals, false)
 
+	// the skipped duplicate is reported by Install
+	Recurse = false
+	defer func() { Recurse = true }()
+	_, restore := fixture.GoPath(c)
+	defer restore()
+
+	vcs := new(fakeVCS)
+	deps := dep.New()
+	deps.Map["a"] = &dep.Dependency{Repo: "repo", Version: "version", SkipCache: true, VCS: vcs}
+	deps.Map["b"] = &dep.Dependency{Repo: "repo", Version: "version", SkipCache: true, VCS: vcs}
+
+	s.buf.Truncate(0)
+	c.Check(Install(deps, util.NewReporter()), IsNil)
+	c.Check(s.buf.String(), Matches, "(?s).*\nSkipping previously installed dependency: repo\n.*")
+	c.Check(vcs.ops, HasLen, 4)
+	util.SetVerbose(false)
+}
+
+func (s *TestSuite) TestParallelInstall(c *C) {
+	Recurse = false
+	defer func() { Recurse = true }()
+	jobs = 4
+
+	vcs := new(fakeVCS)
+	deps := dep.New()
+	for _, name := range []string{"d", "b", "a", "c", "e"} {
+		deps.Map[name] = &dep.Dependency{Repo: "repo_" + name, Version: "v", SkipCache: true, VCS: vcs}
+	}
+
+	err := Install(deps, util.NewReporter())
+	c.Check(err, IsNil)
+	c.Check(len(vcs.ops), Equals, 20)
+
+	// output is flushed in name order regardless of which job finished first
+	out := "Installing:\na (v) *\nb (v) *\nc (v) *\nd (v) *\ne (v) *\n"
+	c.Check(s.buf.String(), Equals, out)
+}
+
+func (s *TestSuite) TestOfflineInstall(c *C) {
+	defer result.Reset()
+	Recurse = false
+	defer func() { Recurse = true }()
+	util.SetOffline(true)
+	defer util.SetOffline(false)
+
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+	os.MkdirAll(filepath.Join(dir, "src", "present"), 0755)
+	os.MkdirAll(filepath.Join(dir, "src", "old"), 0755)
+
+	vcs := new(fakeVCS)
+	deps := dep.New()
+	deps.Map["present"] = &dep.Dependency{Repo: "present", Version: "local", VCS: vcs}
+	deps.Map["old"] = &dep.Dependency{Repo: "old", Version: "remote", VCS: vcs}
+	deps.Map["absent"] = &dep.Dependency{Repo: "absent", Version: "local", VCS: vcs}
+
+	err := Install(deps, util.NewReporter())
+	c.Check(err, Equals, ErrOffline)
+	c.Check(result.ExitCode(), Equals, int(result.Offline))
+
+	// only the local version is checked out, nothing touches the network
+	c.Check(vcs.ops, DeepEquals, []string{"checkout present"})
+	c.Check(missing, DeepEquals, []string{
+		"absent (local) absent: not cloned",
+		"old (remote) old: version not present locally",
+	})
+}
+
+func (s *TestSuite) TestPinnedSkipsFetch(c *C) {
+	Recurse = false
+	defer func() { Recurse = true }()
+
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+	os.MkdirAll(filepath.Join(dir, "src", "pinned"), 0755)
+	os.MkdirAll(filepath.Join(dir, "src", "branch"), 0755)
+
+	vcs := new(fakeVCS)
+	deps := dep.New()
+	deps.Map["pinned"] = &dep.Dependency{Repo: "pinned", Version: "local", SkipCache: true, VCS: vcs}
+	deps.Map["branch"] = &dep.Dependency{Repo: "branch", Version: "master", SkipCache: true, VCS: vcs}
+
+	err := Install(deps, util.NewReporter())
+	c.Check(err, IsNil)
+	c.Check(vcs.ops, DeepEquals, []string{
+		"clone branch", "fetch branch", "checkout branch", "update branch",
+		"clone pinned", "checkout pinned",
+	})
+}
+
+func (s *TestSuite) TestLockTimeout(c *C) {
+	Recurse = false
+	defer func() { Recurse = true }()
+	lockTimeout = 200 * time.Millisecond
+	defer func() { lockTimeout = 5 * time.Minute }()
+
+	dir, restore := fixture.GoPath(c)
+	defer restore()
+
+	vcs := new(fakeVCS)
+	deps := dep.New()
+	deps.Map["locked"] = &dep.Dependency{Repo: "example.com/locked.git/pkg", Version: "master", SkipCache: true, VCS: vcs}
+
+	// another process is installing another package of the same repo
+	l, err := lock(&dep.Dependency{Repo: "example.com/locked.git/other"})
+	c.Assert(err, IsNil)
+	defer l.Release()
+
+	Install(deps, util.NewReporter())
+	c.Check(vcs.ops, HasLen, 0)
+	c.Check(s.buf.String(), Matches, "(?s).*Waiting for another depman process to finish with "+dir+"/src/example.com/locked.git\n.*timed out waiting for lock.*")
+}
+
+func (s *TestSuite) TestInstallErrors(c *C) {
+	Recurse = false
+	defer func() { Recurse = true }()
+	defer result.Reset()
+
+	_, restore := fixture.GoPath(c)
+	defer restore()
+
+	vcs := &fakeVCS{fail: map[string]bool{"fetch bad": true, "checkout worse": true}}
+	deps := dep.New()
+	deps.Path = "/project/deps.json"
+	deps.Map["good"] = &dep.Dependency{Repo: "good", Version: "master", SkipCache: true, VCS: vcs}
+	deps.Map["bad"] = &dep.Dependency{Repo: "bad", Version: "master", SkipCache: true, VCS: vcs}
+	deps.Map["worse"] = &dep.Dependency{Repo: "worse", Version: "master", SkipCache: true, VCS: vcs}
+
+	err := Install(deps, util.NewReporter())
+	errs, ok := err.(result.Errors)
+	c.Assert(ok, Equals, true)
+	c.Assert(errs, HasLen, 2)
+
+	c.Check(errs[0].Dep, Equals, "bad")
+	c.Check(errs[0].Op, Equals, "fetch")
+	c.Check(errs[0].DepsFile, Equals, "/project/deps.json")
+	c.Check(errs[1].Dep, Equals, "worse")
+	c.Check(errs[1].Op, Equals, "checkout")
+	c.Check(err, ErrorMatches, "bad: fetch: exit status 1\nworse: checkout: exit status 1")
+}
+
+func (s *TestSuite) TestVerify(c *C) {
+	defer result.Reset()
+
+	vcs := &fakeVCS{rev: "0123456789abcdef"}
+	d := &dep.Dependency{Repo: "repo", Version: "local", VCS: vcs, Log: util.NewLogger(util.NewReporter(), false, 0)}
+
+	// only pinned dependencies are verified
+	d.Version = "master"
+	c.Check(verify(d), IsNil)
+
+	d.Version = "local"
+	err := verify(d)
+	c.Assert(err, NotNil)
+	c.Check(err.(*result.Error).Kind, Equals, result.Verify)
+	c.Check(result.ExitCode(), Equals, 6)
+
+	vcs.rev = "local"
+	c.Check(verify(d), IsNil)
+}
+
+func (s *TestSuite) TestJSONEvents(c *C) {
+	Recurse = false
+	defer func() { Recurse = true }()
+	defer result.Reset()
+
+	var events bytes.Buffer
+	util.ResultTarget = &events
+	util.SetOutput("json")
+	defer func() {
+		util.ResultTarget = os.Stdout
+		util.SetOutput("text")
+	}()
+
+	_, restore := fixture.GoPath(c)
+	defer restore()
+
+	vcs := &fakeVCS{rev: "abc123", fail: map[string]bool{"checkout bad": true}}
+	deps := dep.New()
+	deps.Map["bad"] = &dep.Dependency{Repo: "bad", Version: "master", SkipCache: true, VCS: vcs}
+	deps.Map["good"] = &dep.Dependency{Repo: "good", Version: "master", SkipCache: true, VCS: vcs}
+
+	Install(deps, util.NewReporter())
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(events.String()), "\n") {
+		var e report.Event
+		c.Assert(json.Unmarshal([]byte(line), &e), IsNil)
+		c.Check(e.Stale, Equals, true)
+		got = append(got, e.Event+" "+e.Name+" "+e.Head)
+	}
+	c.Check(got, DeepEquals, []string{
+		"start bad ", "fetched bad ", "error bad ",
+		"start good ", "fetched good ", "checked-out good abc123",
+	})
 }
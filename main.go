@@ -5,22 +5,21 @@ package main
 //===============================================
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
-	"strings"
+	"time"
 
-	"github.com/vube/depman/add"
+	"github.com/vube/depman/api"
 	"github.com/vube/depman/colors"
-	"github.com/vube/depman/create"
+	"github.com/vube/depman/config"
 	"github.com/vube/depman/dep"
-	"github.com/vube/depman/install"
+	"github.com/vube/depman/report"
 	"github.com/vube/depman/result"
-	"github.com/vube/depman/showfrozen"
 	"github.com/vube/depman/timelock"
-	"github.com/vube/depman/update"
 	"github.com/vube/depman/upgrade"
 	"github.com/vube/depman/util"
 )
@@ -29,133 +28,263 @@ import (
 // This should ALWAYS have 3 parts, (e.g. X.Y.Z) this is so upgrade.Check() works correctly
 const VERSION string = "2.9.5"
 
+var (
+	errUnknownCommand    = errors.New("unknown command")
+	errMissingSubCommand = errors.New("missing sub command")
+	errMissingArguments  = errors.New("missing arguments")
+)
+
 //===============================================
 
 func main() {
 	var help bool
-	var path string
-	var command string
-	var arguments []string
-	var deps dep.DependencyMap
+	var clearCache bool
 	var err error
 
+	s := new(session)
+	s.opts = api.DefaultOptions()
+
+	start := time.Now()
 	log.SetFlags(0)
 
 	flag.BoolVar(&help, "help", false, "Display help")
-	flag.StringVar(&path, "path", ".", "Directory or full path to deps.json")
-	util.Parse()
+	flag.StringVar(&s.path, "path", ".", "Directory or full path to deps.json")
+	flag.BoolVar(&clearCache, "clear-cache", false, "Delete the time based cache")
+	s.opts.Flags(flag.CommandLine)
+	util.Flags(flag.CommandLine)
+	colors.Flags(flag.CommandLine)
+	flag.Usage = Help
+	util.Parse(func() {
+		colors.Detect(util.IsTerminal(os.Stdout) && util.IsTerminal(os.Stderr))
+		s.cfg = loadConfig(s.path)
+	})
+
+	// the command, its flags and its arguments follow the global flags
+	args := flag.Args()
+	if help {
+		args = append([]string{"help"}, args...)
+	} else if len(args) == 0 {
+		args = []string{"install"}
+	}
+	name := args[0]
+
+	c := lookup(name)
+	if c != nil {
+		c, s.args = parseCommand(c, &s.opts, s.cfg, args)
+	}
+
+	api.Configure(s.opts)
+	s.r = util.NewReporter()
+	s.opts.Reporter = s.r
+
+	// the output of completion is read by the shell
+	if c != nil && c.bare {
+		c.run(s)
+		util.OsExit(result.ExitCode())
+	}
+
+	// an unknown command may be a plugin, which replaces depman and reports its own results, so nothing is written
+	// before it runs and depman does not check GOPATH, read the cache or check for upgrades
+	if lookup(name) == nil {
+		if file := plugin(name); file != "" {
+			s.path = dep.GetPath(s.path)
+			util.OsExit(runPlugin(file, args[1:], s))
+		}
+	}
 
 	util.Version(VERSION)
 
-	fmt.Println(colors.Red("Depman was deprecated on 25 February 2015"))
-	fmt.Println(colors.Red("We recommend using 'godep' instead: https://github.com/tools/godep"))
+	// stdout only holds events with --output=json
+	notice := os.Stdout
+	if util.JSON() {
+		notice = os.Stderr
+	}
+	fmt.Fprintln(notice, colors.Red("Depman was deprecated on 25 February 2015"))
+	fmt.Fprintln(notice, colors.Red("We recommend using 'godep' instead: https://github.com/tools/godep"))
+
+	// standalone commands diagnose GOPATH and the cache
+	if c == nil || !c.standalone {
+		util.GoPathIsSet()
+	}
 
-	util.GoPathIsSet()
+	// commands running through package api read and write the cache themselves
+	cached := c == nil || !(c.standalone || c.api)
+	util.CatchInterrupt()
 
-	if timelock.Clear() {
+	if clearCache {
+		err = timelock.Clear(s.r)
+		if err != nil {
+			util.Abort(result.Other, "Error clearing the cache: "+err.Error())
+		}
 		return
 	}
 
-	timelock.Read()
+	if cached {
+		err = timelock.Read(s.r)
+		if err != nil {
+			util.Abort(result.Other, "Error reading the cache: "+err.Error())
+		}
+	}
 
-	// check for a new version of depman
-	go upgrade.Check(VERSION)
-	runtime.Gosched()
-	defer upgrade.Print()
+	// check for a new version of depman, which reads and touches the cache. Commands running through package api
+	// read the cache themselves, so they are checked after they return rather than racing the read
+	checkUpgrade := !util.Offline() && updateCheck
+	if checkUpgrade && (c == nil || !c.api) {
+		go upgrade.Check(VERSION)
+		runtime.Gosched()
+		defer upgrade.Print()
+	}
 
-	path = dep.GetPath(path)
+	s.path = dep.GetPath(s.path)
 
-	if flag.NArg() > 0 {
-		command = strings.ToLower(flag.Arg(0))
+	switch {
+	case lookup(name) == nil:
+		unknown(name)
+	case c != nil:
+		readDeps(c, s)
+		c.run(s)
 	}
 
-	if flag.NArg() > 1 {
-		arguments = flag.Args()[1:]
+	if checkUpgrade && c != nil && c.api && !util.Interrupted() && timelock.Read(s.r) == nil {
+		upgrade.Check(VERSION)
+		upgrade.Print()
+		cached = true
 	}
 
-	if help {
-		command = "help"
+	// written even after an interrupt, only dependencies which completed are marked as fresh
+	if cached {
+		err = timelock.Write(s.r)
+		if err != nil {
+			e := &result.Error{Op: "cache", Err: err}
+			result.Register(e)
+			s.r.Error(report.Dependency{}, e)
+		}
 	}
 
-	// switch to check for deps.json
-	switch command {
-	case "add", "", "install", "update", "show-frozen":
-		// check for deps.json
-		util.CheckPath(path)
-		deps, err = dep.Read(path)
-		if err != nil {
-			util.Fatal(colors.Red("Error Reading deps.json: " + err.Error()))
+	summary := report.Summary{ExitCode: result.ExitCode(), Duration: time.Since(start), Errors: result.All()}
+	if s.summary != nil {
+		summary = *s.summary
+	}
+	if util.Interrupted() {
+		summary.ExitCode = 130
+	}
+
+	// show-frozen writes a single JSON array instead of events
+	if name != "show-frozen" || !util.JSON() {
+		s.r.Summary(summary)
+	}
+
+	if summary.ExitCode != 0 {
+		util.OsExit(summary.ExitCode)
+	}
+}
+
+// parseCommand parses the flags of c from args (the command line after the global flags, starting with the name of c),
+// binding them to o with the defaults in cfg, and selects the sub command. It returns the command to run and its
+// arguments, or a nil command if the command line is invalid, in which case the error has been registered and displayed
+func parseCommand(c *command, o *api.Options, cfg *config.Config, args []string) (*command, []string) {
+	fs := c.flagSet(o, log.Writer())
+	err := cfg.Apply(fs)
+	if err != nil {
+		result.Register(&result.Error{Kind: result.Config, Op: c.name, Err: err})
+		log.Println(colors.Red("Error reading the configuration: " + err.Error()))
+		return nil, nil
+	}
+
+	err = fs.Parse(args[1:])
+	if err == flag.ErrHelp {
+		// the help of c has been displayed, nothing else runs
+		util.OsExit(0)
+		return nil, nil
+	} else if err != nil {
+		result.Register(&result.Error{Kind: result.Config, Op: c.name, Err: err})
+		return nil, nil
+	}
+	args = fs.Args()
+
+	if len(c.subs) > 0 {
+		var sub *command
+		if len(args) > 0 {
+			sub = find(c.subs, args[0])
 		}
+		if sub == nil {
+			result.Register(&result.Error{Kind: result.Config, Op: c.name, Err: errMissingSubCommand})
+			log.Println(colors.Red(title(c.name) + " command requires a sub command"))
+			c.help(log.Writer())
+			return nil, nil
+		}
+		c, args = sub, args[1:]
 	}
 
-	// switch to exec the sub command
-	switch command {
-	case "init", "create":
-		create.Create(path)
-	case "add":
-		if len(arguments) < 1 {
-			util.Print(colors.Red("Add command requires 1 argument: Add [nickname]"))
-			Help()
-		} else {
-			add.Add(deps, arguments[0])
+	if len(args) < c.minArgs {
+		result.Register(&result.Error{Kind: result.Config, Op: c.name, Err: errMissingArguments})
+		log.Println(colors.Red(fmt.Sprintf("%s command requires %d argument(s): %s", title(c.name), c.minArgs, title(c.usage()))))
+		c.help(log.Writer())
+		return nil, nil
+	}
+	return c, args
+}
+
+// readDeps reads deps.json for c, as required by the top level command
+func readDeps(c *command, s *session) {
+	mode := c.deps
+	for _, top := range commands {
+		if find(top.subs, c.name) == c {
+			mode = top.deps
 		}
+	}
 
-	case "update":
-		if len(arguments) < 2 {
-			util.Print(colors.Red("Update command requires 2 arguments: Update [nickname] [branch]"))
-			Help()
-		} else {
-			update.Update(deps, arguments[0], arguments[1])
+	switch mode {
+	case depsRequired:
+		util.CheckPath(s.path)
+
+		// package api reads deps.json itself
+		if c.api {
+			return
 		}
-	case "install", "":
-		install.Install(deps)
-	case "self-upgrade":
-		upgrade.Self(VERSION)
-	case "show-frozen":
-		var recursive bool
-		flagset := flag.NewFlagSet("show-frozen", flag.ExitOnError)
-		flagset.BoolVar(&recursive, "recursive", false, "descend recursively (depth-first) into dependencies")
-		flagset.Parse(flag.Args()[1:])
-
-		if recursive {
-			fmt.Println(showfrozen.ReadRecursively(deps, nil))
-		} else {
-			fmt.Print(showfrozen.Read(deps))
+	case depsOptional:
+		// only used to look up nicknames and per dependency ttls
+		s.deps = dep.New()
+		if !util.Exists(s.path) {
+			return
 		}
 	default:
-		result.RegisterError()
-		log.Println(colors.Red("Unknown Command: " + command))
-		fallthrough
-	case "help":
-		Help()
+		return
 	}
 
-	timelock.Write()
-
-	if result.ShouldExitWithError() {
-		os.Exit(1)
-	} else {
-		util.Print("Success")
+	var err error
+	s.deps, err = dep.Read(s.path, s.r)
+	if err != nil {
+		util.Abort(result.Config, "Error Reading deps.json: "+err.Error())
 	}
 }
 
 //===============================================
 
-// Help prints the help message for depman
+// Help prints the help message for depman, generated from the commands
 func Help() {
 	log.Println("")
 	log.Println("Commands:")
-	log.Println("   Init                        : Create an empty deps.json")
-	log.Println("   Add [nickname]              : Add a dependency (interactive)")
-	log.Println("   Install                     : Install all the dependencies listed in deps.json (default)")
-	log.Println("   Update [nickname] [branch]  : Update [nickname] to use the latest commit in [branch]")
-	log.Println("   Self-Upgrade                : Upgrade depman to the latest version on the master branch")
-	log.Println("   Help                        : Display this help")
-	log.Println("   Show-Frozen                 : Show dependencies as resolved to commit IDs")
+	for _, c := range commands {
+		for _, l := range c.lines() {
+			log.Println(l)
+		}
+	}
+
+	if lines := pluginLines(); len(lines) > 0 {
+		log.Println("")
+		log.Println("Plugins (depman-[command] on PATH):")
+		for _, l := range lines {
+			log.Println(l)
+		}
+	}
+
 	log.Println("")
-	log.Println("Example: depman --verbose install")
+	log.Println("Example: depman --verbose install --clean")
 	log.Println("")
-	//log.Println("   freeze                      : For each dependency change tag and branch versions to commits (not yet implemented)")
 	log.Println("Options:")
+	flag.CommandLine.SetOutput(log.Writer())
 	flag.PrintDefaults()
+	log.Println("")
+	log.Println("Run 'depman help [command]' for the options of a command")
 }
@@ -0,0 +1,330 @@
+// Package mirror maintains a shared directory of local mirrors of dependency repositories, one per repo url.
+// When enabled with SetEnabled (--mirror), clones and fetches update the mirror from the network and then copy from it locally,
+// so projects and workspaces which share a dependency only download it once.
+// The location of the mirrors is controlled by SetDir (--mirror-dir)
+package mirror
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/flock"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/util"
+)
+
+// Supported mirror types, these match the dependency types in package dep
+const (
+	TypeGit = "git"
+	TypeHg  = "hg"
+	TypeBzr = "bzr"
+)
+
+// the filename of the index of mirrors, in the mirror directory
+const indexFileName = "index.json"
+
+// settings
+var (
+	enabled bool
+	dir     = DefaultDir()
+)
+
+var (
+	// guards the index file
+	indexLock sync.Mutex
+
+	// one lock per mirror, so that dependencies sharing a mirror don't update it concurrently
+	locks     = make(map[string]*sync.Mutex)
+	locksLock sync.Mutex
+)
+
+// Entry describes a single mirror in the index
+type Entry struct {
+	URL      string    `json:"url"`
+	Type     string    `json:"type"`
+	LastUsed time.Time `json:"last-used"`
+}
+
+// DefaultDir returns ~/.depman/mirrors
+func DefaultDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".depman", "mirrors")
+}
+
+// Enabled returns true if clones and fetches should use the mirrors (--mirror)
+func Enabled() bool {
+	return enabled && dir != ""
+}
+
+// SetEnabled sets whether clones and fetches should use the mirrors
+func SetEnabled(e bool) {
+	enabled = e
+}
+
+// SetDir sets the directory holding the mirrors
+func SetDir(d string) {
+	dir = d
+}
+
+// Dir returns the directory holding the mirrors
+func Dir() string {
+	return dir
+}
+
+// Path returns the directory of the mirror for url
+// The name is readable, with a hash suffix to keep urls which only differ in punctuation apart
+func Path(url string) string {
+	name := url
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(dir, fmt.Sprintf("%s-%x", name, sum[:4]))
+}
+
+// RepoRoot returns the import path of the repo holding the package importPath, and the url go get would clone it
+// from. ok is false if that can't be worked out without asking the server, as go get does for most hosts
+func RepoRoot(importPath string) (root string, url string, ok bool) {
+	parts := strings.Split(importPath, "/")
+
+	switch parts[0] {
+	case "github.com", "bitbucket.org":
+		if len(parts) < 3 {
+			return
+		}
+		root = strings.Join(parts[:3], "/")
+		return root, "https://" + root, true
+	}
+
+	// an element ending in a VCS suffix, e.g. example.com/repo.git/pkg, marks the root explicitly
+	for i, p := range parts {
+		if i > 0 && (strings.HasSuffix(p, ".git") || strings.HasSuffix(p, ".hg") || strings.HasSuffix(p, ".bzr")) {
+			root = strings.Join(parts[:i+1], "/")
+			return root, "https://" + root, true
+		}
+	}
+	return
+}
+
+// Update creates or updates the mirror for url from the network, and returns its path.
+// Output is written to l
+func Update(l *util.Logger, vcs string, url string) (path string, err error) {
+	path = Path(url)
+
+	lock := mirrorLock(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// other depman processes may be updating the same mirror
+	os.MkdirAll(dir, 0755)
+	fl, err := acquire(l, path)
+	if err != nil {
+		return
+	}
+	defer fl.Release()
+
+	if util.Exists(path) {
+		l.VerboseIndent("# updating mirror " + path)
+		switch vcs {
+		case TypeGit:
+			err = l.RunNetwork(path, nil, "git", "remote", "update", "--prune")
+		case TypeHg:
+			err = l.RunNetwork(path, nil, "hg", "pull")
+		case TypeBzr:
+			err = l.RunNetwork(path, nil, "bzr", "pull")
+		}
+	} else {
+		l.VerboseIndent("# creating mirror " + path)
+
+		remove := func() { os.RemoveAll(path) }
+		switch vcs {
+		case TypeGit:
+			err = l.RunNetwork("", remove, "git", "clone", "--mirror", "--", url, path)
+		case TypeHg:
+			err = l.RunNetwork("", remove, "hg", "clone", "--noupdate", "--", url, path)
+		case TypeBzr:
+			err = l.RunNetwork("", remove, "bzr", "branch", "--no-tree", "--", url, path)
+		}
+		if err != nil {
+			remove()
+		}
+	}
+
+	if err == nil {
+		touch(l, url, vcs)
+	}
+	return
+}
+
+// mirrorLock returns the lock for the mirror at path
+func mirrorLock(path string) *sync.Mutex {
+	locksLock.Lock()
+	defer locksLock.Unlock()
+
+	lock, ok := locks[path]
+	if !ok {
+		lock = new(sync.Mutex)
+		locks[path] = lock
+	}
+	return lock
+}
+
+// acquire locks path, a mirror or the index, against other depman processes.
+// A mirror is always locked before the index, so processes never wait on each other in a cycle
+func acquire(l *util.Logger, path string) (*flock.Lock, error) {
+	return flock.Acquire(path+".lock", 0, func(waited time.Duration) {
+		if waited == 0 {
+			l.VerboseIndent("Waiting for another depman process to release " + path)
+		} else {
+			l.Notice("Still waiting after " + waited.String() + " for another depman process to release " + path)
+		}
+	})
+}
+
+// touch records that the mirror for url was used, the caller must hold the lock of the mirror
+func touch(l *util.Logger, url string, vcs string) {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	fl, err := acquire(l, filepath.Join(dir, indexFileName))
+	if err != nil {
+		l.Warn("Error updating the mirror index: " + err.Error())
+		return
+	}
+	defer fl.Release()
+
+	index := readIndex(l)
+	index[filepath.Base(Path(url))] = &Entry{URL: url, Type: vcs, LastUsed: time.Now()}
+	writeIndex(l, index)
+}
+
+// GC removes mirrors which have not been used in the given number of days, and reports them to r
+func GC(days int, r report.Reporter) {
+	l := util.NewLogger(r, false, 0)
+	l.Print(colors.Blue("Removing mirrors unused for " + fmt.Sprint(days) + " days:"))
+
+	// the index is read again with each mirror locked, as other processes may have used it since
+	for name := range unused(l, days) {
+		err := remove(l, name, days)
+		if err != nil {
+			l.PrintIndent(colors.Red(name + ": " + err.Error()))
+		}
+	}
+}
+
+// unused returns the index entries of the mirrors which have not been used in the given number of days
+func unused(l *util.Logger, days int) (old map[string]*Entry) {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	old = make(map[string]*Entry)
+	fl, err := acquire(l, filepath.Join(dir, indexFileName))
+	if err != nil {
+		l.Warn("Error reading the mirror index: " + err.Error())
+		return
+	}
+	defer fl.Release()
+
+	for name, e := range readIndex(l) {
+		if time.Since(e.LastUsed) >= time.Duration(days)*24*time.Hour {
+			old[name] = e
+		}
+	}
+	return
+}
+
+// remove removes the mirror called name if it is still unused in the given number of days, and its index entry
+func remove(l *util.Logger, name string, days int) (err error) {
+	path := filepath.Join(dir, name)
+
+	lock := mirrorLock(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	fl, err := acquire(l, path)
+	if err != nil {
+		return
+	}
+	defer fl.Release()
+
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	il, err := acquire(l, filepath.Join(dir, indexFileName))
+	if err != nil {
+		return
+	}
+	defer il.Release()
+
+	index := readIndex(l)
+	e, ok := index[name]
+	if !ok || time.Since(e.LastUsed) < time.Duration(days)*24*time.Hour {
+		return
+	}
+
+	l.PrintIndent(name + " " + e.URL)
+	err = os.RemoveAll(path)
+	if err != nil {
+		return
+	}
+	delete(index, name)
+	writeIndex(l, index)
+	return
+}
+
+// readIndex reads the index of mirrors, a missing or unreadable index is treated as empty.
+// The caller must hold the lock of the index
+func readIndex(l *util.Logger) (index map[string]*Entry) {
+	index = make(map[string]*Entry)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, indexFileName))
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		l.Warn("Warning: ignoring unreadable mirror index: " + err.Error())
+		index = make(map[string]*Entry)
+	}
+	return
+}
+
+// writeIndex writes the index of mirrors, the caller must hold the lock of the index
+func writeIndex(l *util.Logger, index map[string]*Entry) {
+	var buf bytes.Buffer
+	str, err := json.Marshal(index)
+	if err != nil {
+		return
+	}
+	json.Indent(&buf, str, "", "    ")
+
+	os.MkdirAll(dir, 0755)
+	err = util.WriteAtomic(filepath.Join(dir, indexFileName), []byte(buf.String()+"\n"))
+	if err != nil {
+		l.Warn("Error writing mirror index: " + err.Error())
+	}
+}
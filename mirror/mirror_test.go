@@ -0,0 +1,110 @@
+package mirror
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/flock"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/util"
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func TestMirror(t *testing.T) {
+	TestingT(t)
+}
+
+type MirrorSuite struct {
+	dir string
+}
+
+var _ = Suite(&MirrorSuite{})
+
+func (s *MirrorSuite) SetUpTest(c *C) {
+	colors.Mock()
+	util.Mock(new(bytes.Buffer))
+
+	var err error
+	s.dir, err = ioutil.TempDir("", "DepmanUnitTest")
+	c.Assert(err, IsNil)
+	dir = s.dir
+}
+
+func (s *MirrorSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *MirrorSuite) TestPath(c *C) {
+	p := Path("https://github.com/vube/depman.git")
+	c.Check(filepath.Dir(p), Equals, s.dir)
+	c.Check(filepath.Base(p), Matches, `github\.com_vube_depman\.git-[0-9a-f]{8}`)
+
+	// urls which only differ in punctuation get different mirrors
+	c.Check(Path("git@github.com:vube/depman.git"), Not(Equals), Path("git@github.com/vube/depman.git"))
+}
+
+func (s *MirrorSuite) TestRepoRoot(c *C) {
+	root, url, ok := RepoRoot("github.com/vube/depman/util")
+	c.Check(ok, Equals, true)
+	c.Check(root, Equals, "github.com/vube/depman")
+	c.Check(url, Equals, "https://github.com/vube/depman")
+
+	root, url, ok = RepoRoot("example.com/team/repo.hg/pkg")
+	c.Check(ok, Equals, true)
+	c.Check(root, Equals, "example.com/team/repo.hg")
+	c.Check(url, Equals, "https://example.com/team/repo.hg")
+
+	// other hosts are asked by go get
+	_, _, ok = RepoRoot("launchpad.net/gocheck")
+	c.Check(ok, Equals, false)
+	_, _, ok = RepoRoot("github.com/vube")
+	c.Check(ok, Equals, false)
+}
+
+func (s *MirrorSuite) TestGC(c *C) {
+	old := "https://example.com/old.git"
+	recent := "https://example.com/recent.git"
+
+	os.MkdirAll(Path(old), 0755)
+	os.MkdirAll(Path(recent), 0755)
+
+	var buf bytes.Buffer
+	r := report.NewText(log.New(&buf, "", 0), &buf)
+	l := util.NewLogger(r, false, 0)
+	writeIndex(l, map[string]*Entry{
+		filepath.Base(Path(old)):    {URL: old, Type: TypeGit, LastUsed: time.Now().Add(-40 * 24 * time.Hour)},
+		filepath.Base(Path(recent)): {URL: recent, Type: TypeGit, LastUsed: time.Now().Add(-time.Hour)},
+	})
+
+	// another process is using the old mirror
+	fl, err := flock.Acquire(Path(old)+".lock", 0, nil)
+	c.Assert(err, IsNil)
+
+	done := make(chan bool)
+	go func() {
+		GC(30, r)
+		close(done)
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	c.Check(util.Exists(Path(old)), Equals, true)
+	fl.Release()
+	<-done
+
+	c.Check(util.Exists(Path(old)), Equals, false)
+	c.Check(util.Exists(Path(recent)), Equals, true)
+	c.Check(buf.String(), Equals, "Removing mirrors unused for 30 days:\n | "+filepath.Base(Path(old))+" "+old+"\n")
+
+	index := readIndex(l)
+	c.Check(len(index), Equals, 1)
+	c.Check(index[filepath.Base(Path(recent))].URL, Equals, recent)
+}
@@ -0,0 +1,192 @@
+package main
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/util"
+)
+
+// pluginPrefix is the prefix of the executables on PATH which are run as depman commands,
+// e.g. 'depman foo' runs depman-foo
+const pluginPrefix = "depman-"
+
+// pluginDep is a dependency in the tree passed to plugins in DEPMAN_TREE_FILE
+type pluginDep struct {
+	Name    string `json:"name"`
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	Path    string `json:"path"`
+
+	// the deps.json of the dependency and the dependencies it declares, only set if it is installed
+	DepsFile string      `json:"deps_file,omitempty"`
+	Deps     []pluginDep `json:"deps,omitempty"`
+}
+
+// plugins returns the names of the plugins on PATH, sorted, without the prefix and without those hidden by a built
+// in command or by a plugin earlier in PATH
+func plugins() (names []string) {
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range files {
+			name := strings.TrimPrefix(f.Name(), pluginPrefix)
+			if name == f.Name() || name == "" || seen[name] || lookup(name) != nil || plugin(name) == "" {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// plugin returns the path of the executable run for the command name, the empty string if there is none
+func plugin(name string) string {
+	// anything else is looked up relative to the working directory, not on PATH
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+
+	file, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return ""
+	}
+	return file
+}
+
+// runPlugin runs the plugin file with args, in the environment of depman plus:
+//
+//	DEPMAN_DEPS_FILE: the absolute path of deps.json, empty if there is none
+//	DEPMAN_TREE_FILE: a temporary file holding the dependencies in deps.json and their own dependencies, as a JSON
+//	array of pluginDep, it is removed when the plugin exits
+//	DEPMAN_VERSION: the version of depman
+//
+// It returns the exit code of the plugin
+func runPlugin(file string, args []string, s *session) int {
+	var path string
+	tree := []pluginDep{}
+
+	if util.Exists(s.path) {
+		path, _ = filepath.Abs(s.path)
+
+		deps, err := dep.Read(path, s.r)
+		if err != nil {
+			// the plugin may not need the dependencies, e.g. if it repairs deps.json
+			s.r.Warning(report.NoIndent, "Error Reading deps.json: "+err.Error())
+		} else {
+			tree = dependencyTree(deps, map[string]bool{}, s.r)
+		}
+	}
+
+	// the tree of a large project would not fit in the environment
+	treeFile, err := writeTree(tree)
+	if err != nil {
+		return pluginFailed(file, err, s)
+	}
+	defer os.Remove(treeFile)
+
+	cmd := exec.Command(file, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"DEPMAN_DEPS_FILE="+path,
+		"DEPMAN_TREE_FILE="+treeFile,
+		"DEPMAN_VERSION="+VERSION,
+	)
+
+	err = cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode()
+	} else if err != nil {
+		return pluginFailed(file, err, s)
+	}
+	return 0
+}
+
+// writeTree writes tree as JSON to a new temporary file, returning its path
+func writeTree(tree []pluginDep) (path string, err error) {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile("", "depman-tree")
+	if err != nil {
+		return
+	}
+	path = f.Name()
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return
+}
+
+// pluginFailed registers and reports that the plugin file could not be run, returning the exit code
+func pluginFailed(file string, err error, s *session) int {
+	e := &result.Error{Kind: result.Other, Op: "plugin", Command: file, Err: err}
+	result.Register(e)
+	s.r.Error(report.Dependency{}, e)
+	return result.ExitCode()
+}
+
+// dependencyTree returns the dependencies in deps sorted by name, with the dependencies of those which are installed.
+// The dependencies of a repo are only listed the first time it is seen, and problems reading them are reported to r
+func dependencyTree(deps dep.DependencyMap, seen map[string]bool, r report.Reporter) (tree []pluginDep) {
+	var names []string
+	for name := range deps.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		d := deps.Map[name]
+		p := pluginDep{Name: name, Repo: d.Repo, Version: d.Version, Type: d.Type, Path: d.Path()}
+
+		if !seen[d.Repo] && util.Exists(p.Path) {
+			seen[d.Repo] = true
+
+			depsFile := util.UpwardFind(p.Path, dep.DepsFile)
+			if depsFile != "" && depsFile != deps.Path {
+				sub, err := dep.Read(depsFile, r)
+				if err == nil {
+					p.DepsFile = depsFile
+					p.Deps = dependencyTree(sub, seen, r)
+				}
+			}
+		}
+		tree = append(tree, p)
+	}
+	return
+}
+
+// pluginLines returns the lines describing the plugins on PATH in the list of commands
+func pluginLines() (lines []string) {
+	for _, name := range plugins() {
+		lines = append(lines, fmt.Sprintf("   %-28s: %s", title(name), "Run "+plugin(name)))
+	}
+	return
+}
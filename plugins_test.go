@@ -0,0 +1,117 @@
+package main
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "launchpad.net/gocheck"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+)
+
+type PluginSuite struct {
+	dir     string
+	path    string
+	restore func()
+	buf     *bytes.Buffer
+}
+
+var _ = Suite(&PluginSuite{})
+
+func (s *PluginSuite) SetUpTest(c *C) {
+	colors.Mock()
+
+	s.dir, s.restore = fixture.GoPath(c)
+
+	s.path = os.Getenv("PATH")
+	os.Setenv("PATH", filepath.Join(s.dir, "bin"))
+
+	c.Assert(os.Mkdir(filepath.Join(s.dir, "bin"), 0755), IsNil)
+	s.buf = new(bytes.Buffer)
+}
+
+func (s *PluginSuite) TearDownTest(c *C) {
+	os.Setenv("PATH", s.path)
+	s.restore()
+	result.Reset()
+}
+
+// writePlugin writes an executable called depman-name running the shell script body
+func (s *PluginSuite) writePlugin(c *C, name string, body string) {
+	file := filepath.Join(s.dir, "bin", pluginPrefix+name)
+	c.Assert(ioutil.WriteFile(file, []byte("#!/bin/sh\n"+body+"\n"), 0755), IsNil)
+}
+
+func (s *PluginSuite) session() *session {
+	return &session{
+		path: filepath.Join(s.dir, "deps.json"),
+		r:    report.NewText(log.New(s.buf, "", 0), s.buf),
+	}
+}
+
+func (s *PluginSuite) TestPlugins(c *C) {
+	s.writePlugin(c, "wiki", "true")
+	s.writePlugin(c, "install", "true")
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "bin", "depman-data"), nil, 0644), IsNil)
+
+	// built in commands and files which are not executable are not plugins
+	c.Check(plugins(), DeepEquals, []string{"wiki"})
+	c.Check(plugin("wiki"), Equals, filepath.Join(s.dir, "bin", "depman-wiki"))
+	c.Check(plugin("data"), Equals, "")
+	c.Check(plugin("../bin/depman-wiki"), Equals, "")
+	c.Check(pluginLines(), DeepEquals, []string{"   Wiki" + strings.Repeat(" ", 24) + ": Run " + plugin("wiki")})
+}
+
+func (s *PluginSuite) TestRunPlugin(c *C) {
+	out := filepath.Join(s.dir, "out")
+	s.writePlugin(c, "wiki", `read -r tree < "$DEPMAN_TREE_FILE"; echo "$1 $DEPMAN_DEPS_FILE $GOPATH $DEPMAN_TREE_FILE $tree" > `+out+`; exit 3`)
+
+	deps := `{"none": {"repo": "github.com/vube/depman-test-none", "version": "master", "type": "git"}}`
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "deps.json"), []byte(deps), 0644), IsNil)
+
+	code := runPlugin(plugin("wiki"), []string{"publish"}, s.session())
+	c.Check(code, Equals, 3)
+
+	data, err := ioutil.ReadFile(out)
+	c.Assert(err, IsNil)
+
+	var tree []pluginDep
+	prefix := "publish " + filepath.Join(s.dir, "deps.json") + " " + s.dir + " "
+	c.Assert(string(data[:len(prefix)]), Equals, prefix)
+
+	// the tree file is removed once the plugin exits
+	fields := strings.SplitN(string(data[len(prefix):]), " ", 2)
+	c.Assert(fields, HasLen, 2)
+	_, err = os.Stat(fields[0])
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	c.Assert(json.Unmarshal([]byte(fields[1]), &tree), IsNil)
+	c.Check(tree, DeepEquals, []pluginDep{{
+		Name:    "none",
+		Repo:    "github.com/vube/depman-test-none",
+		Version: "master",
+		Type:    "git",
+		Path:    filepath.Join(s.dir, "src", "github.com/vube/depman-test-none"),
+	}})
+}
+
+func (s *PluginSuite) TestRunPluginWithoutDeps(c *C) {
+	out := filepath.Join(s.dir, "out")
+	s.writePlugin(c, "wiki", `read -r tree < "$DEPMAN_TREE_FILE"; echo "[$DEPMAN_DEPS_FILE] $tree" > `+out)
+
+	c.Check(runPlugin(plugin("wiki"), nil, s.session()), Equals, 0)
+
+	data, err := ioutil.ReadFile(out)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "[] []\n")
+}
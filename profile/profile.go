@@ -0,0 +1,288 @@
+// Package profile records the time taken by each operation of an install when enabled with SetEnabled (--profile).
+// Report displays the slowest dependencies and operations, WriteTrace writes every operation as a Chrome trace
+// (--profile-trace) which can be opened in chrome://tracing or https://ui.perfetto.dev
+package profile
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/report"
+)
+
+// OpRecurse is the installation of the dependencies of a dependency, the other operations are those of install
+const OpRecurse = "recurse"
+
+// Top is the number of dependencies and operations displayed by Report
+const Top = 10
+
+var (
+	enabled bool
+
+	lock  sync.Mutex
+	spans []Span
+
+	// when the run started, the times in traces are relative to it
+	start = time.Now()
+)
+
+// Span is the time taken by an operation on a dependency
+type Span struct {
+	Name     string
+	Repo     string
+	Op       string
+	Start    time.Time
+	Duration time.Duration
+}
+
+// SetEnabled enables or disables the recording of operations
+func SetEnabled(e bool) {
+	enabled = e
+}
+
+// Enabled returns true if operations are recorded
+func Enabled() bool {
+	return enabled
+}
+
+// Reset forgets the operations recorded so far, the run starts now
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	spans = nil
+	start = time.Now()
+}
+
+// Spans returns the operations recorded, in the order they ended
+func Spans() []Span {
+	lock.Lock()
+	defer lock.Unlock()
+	return append([]Span(nil), spans...)
+}
+
+// Timer times the operations on a dependency, one after the other. A nil Timer records nothing
+type Timer struct {
+	name string
+	repo string
+
+	// the operation in progress
+	op    string
+	start time.Time
+}
+
+// Start returns a Timer for the dependency name of repo, or nil if profiling is disabled
+func Start(name, repo string) *Timer {
+	if !enabled {
+		return nil
+	}
+	return &Timer{name: name, repo: repo}
+}
+
+// Op ends the operation in progress and starts op
+func (t *Timer) Op(op string) {
+	if t == nil {
+		return
+	}
+	t.Stop()
+	t.op = op
+	t.start = time.Now()
+}
+
+// Stop ends the operation in progress
+func (t *Timer) Stop() {
+	if t == nil || t.op == "" {
+		return
+	}
+
+	lock.Lock()
+	spans = append(spans, Span{Name: t.name, Repo: t.repo, Op: t.op, Start: t.start, Duration: time.Since(t.start)})
+	lock.Unlock()
+	t.op = ""
+}
+
+// Dependency is the time taken by a dependency, and by each of its operations
+type Dependency struct {
+	Name  string
+	Repo  string
+	Total time.Duration
+
+	// the operations sorted from the slowest, the time of an operation done more than once is added up
+	Ops []Span
+}
+
+// Dependencies returns the time taken by each dependency, from the slowest.
+// The installation of its own dependencies (OpRecurse) is not included
+func Dependencies() (deps []Dependency) {
+	index := make(map[string]int)
+	for _, s := range Spans() {
+		if s.Op == OpRecurse {
+			continue
+		}
+
+		i, ok := index[s.Repo]
+		if !ok {
+			i = len(deps)
+			index[s.Repo] = i
+			deps = append(deps, Dependency{Name: s.Name, Repo: s.Repo})
+		}
+
+		d := &deps[i]
+		d.Total += s.Duration
+
+		found := false
+		for j := range d.Ops {
+			if d.Ops[j].Op == s.Op {
+				d.Ops[j].Duration += s.Duration
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.Ops = append(d.Ops, s)
+		}
+	}
+
+	for _, d := range deps {
+		sort.Stable(slowest(d.Ops))
+	}
+	sort.Stable(byTotal(deps))
+	return
+}
+
+// Operations returns every operation recorded, from the slowest.
+// The installation of the dependencies of a dependency (OpRecurse) is not included, it is made of their operations
+func Operations() (ops []Span) {
+	for _, s := range Spans() {
+		if s.Op != OpRecurse {
+			ops = append(ops, s)
+		}
+	}
+	sort.Stable(slowest(ops))
+	return
+}
+
+// Report displays the Top slowest dependencies and operations to r
+func Report(r report.Reporter) {
+	deps := Dependencies()
+	ops := Operations()
+
+	var total time.Duration
+	for _, d := range deps {
+		total += d.Total
+	}
+	r.Info(report.NoIndent, colors.Blue("Profile: ")+fmt.Sprintf("%s in %d operations on %d dependencies", seconds(total), len(ops), len(deps)))
+	if len(deps) == 0 {
+		return
+	}
+
+	table := func(title string, write func(w *tabwriter.Writer)) {
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		write(w)
+		w.Flush()
+
+		r.Info(report.NoIndent, colors.Blue(title))
+		for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+			r.Info(report.NoIndent, line)
+		}
+	}
+
+	table("Slowest dependencies:", func(w *tabwriter.Writer) {
+		for i, d := range deps {
+			if i == Top {
+				break
+			}
+
+			var parts []string
+			for _, op := range d.Ops {
+				parts = append(parts, op.Op+" "+seconds(op.Duration))
+			}
+			fmt.Fprintf(w, "%9s\t%s\t%s\n", seconds(d.Total), d.Name, strings.Join(parts, ", "))
+		}
+	})
+
+	table("Slowest operations:", func(w *tabwriter.Writer) {
+		for i, op := range ops {
+			if i == Top {
+				break
+			}
+			fmt.Fprintf(w, "%9s\t%s\t%s\n", seconds(op.Duration), op.Name, op.Op)
+		}
+	})
+}
+
+// seconds formats d like the time to install displayed by --verbose
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+// traceEvent is an event of the Chrome trace event format, times are in microseconds
+type traceEvent struct {
+	Name  string            `json:"name"`
+	Cat   string            `json:"cat,omitempty"`
+	Phase string            `json:"ph"`
+	Time  int64             `json:"ts"`
+	Dur   int64             `json:"dur,omitempty"`
+	PID   int               `json:"pid"`
+	TID   int               `json:"tid"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// WriteTrace writes the operations recorded to file in the Chrome trace event format, with one row per dependency
+func WriteTrace(file string) error {
+	lock.Lock()
+	from := start
+	lock.Unlock()
+
+	events := []traceEvent{}
+	rows := make(map[string]int)
+	for _, s := range Spans() {
+		tid, ok := rows[s.Repo]
+		if !ok {
+			tid = len(rows) + 1
+			rows[s.Repo] = tid
+			events = append(events, traceEvent{Name: "thread_name", Phase: "M", PID: 1, TID: tid, Args: map[string]string{"name": s.Name}})
+		}
+
+		events = append(events, traceEvent{
+			Name:  s.Op,
+			Cat:   "depman",
+			Phase: "X",
+			Time:  int64(s.Start.Sub(from) / time.Microsecond),
+			Dur:   int64(s.Duration / time.Microsecond),
+			PID:   1,
+			TID:   tid,
+			Args:  map[string]string{"dependency": s.Name, "repo": s.Repo},
+		})
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{"traceEvents": events, "displayTimeUnit": "ms"}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// slowest sorts spans from the slowest
+type slowest []Span
+
+func (s slowest) Len() int           { return len(s) }
+func (s slowest) Less(i, j int) bool { return s[i].Duration > s[j].Duration }
+func (s slowest) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// byTotal sorts dependencies from the slowest
+type byTotal []Dependency
+
+func (s byTotal) Len() int           { return len(s) }
+func (s byTotal) Less(i, j int) bool { return s[i].Total > s[j].Total }
+func (s byTotal) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
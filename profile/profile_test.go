@@ -0,0 +1,149 @@
+package profile
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/report"
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func TestProfile(t *testing.T) {
+	TestingT(t)
+}
+
+type ProfileSuite struct{}
+
+var _ = Suite(&ProfileSuite{})
+
+func (s *ProfileSuite) SetUpTest(c *C) {
+	colors.Mock()
+	Reset()
+}
+
+func (s *ProfileSuite) TearDownTest(c *C) {
+	SetEnabled(false)
+	Reset()
+}
+
+// record adds a span of d seconds for the dependency name, starting at offset seconds into the run
+func record(name, op string, offset, d float64) {
+	at := start.Add(time.Duration(offset * float64(time.Second)))
+	spans = append(spans, Span{Name: name, Repo: "example.com/" + name, Op: op, Start: at, Duration: time.Duration(d * float64(time.Second))})
+}
+
+func (s *ProfileSuite) TestTimer(c *C) {
+	// disabled
+	t := Start("foo", "example.com/foo")
+	c.Check(t, IsNil)
+	t.Op("clone")
+	t.Stop()
+	c.Check(Spans(), HasLen, 0)
+
+	SetEnabled(true)
+	t = Start("foo", "example.com/foo")
+	t.Op("clone")
+	t.Op("checkout")
+	t.Stop()
+	t.Stop()
+
+	spans := Spans()
+	c.Assert(spans, HasLen, 2)
+	c.Check(spans[0].Op, Equals, "clone")
+	c.Check(spans[1].Op, Equals, "checkout")
+	c.Check(spans[1].Repo, Equals, "example.com/foo")
+	c.Check(spans[1].Start.Before(spans[0].Start), Equals, false)
+}
+
+func (s *ProfileSuite) TestDependencies(c *C) {
+	record("foo", "clone", 0, 1)
+	record("bar", "fetch", 0, 0.5)
+	record("foo", "fetch", 1, 0.5)
+	record("foo", "fetch", 1.5, 2)
+	record("foo", OpRecurse, 3.5, 10)
+
+	deps := Dependencies()
+	c.Assert(deps, HasLen, 2)
+	c.Check(deps[0].Name, Equals, "foo")
+	c.Check(deps[0].Total, Equals, 3500*time.Millisecond)
+	c.Assert(deps[0].Ops, HasLen, 2)
+	c.Check(deps[0].Ops[0].Op, Equals, "fetch")
+	c.Check(deps[0].Ops[0].Duration, Equals, 2500*time.Millisecond)
+	c.Check(deps[1].Name, Equals, "bar")
+
+	// recursing is not an operation of its own
+	ops := Operations()
+	c.Assert(ops, HasLen, 4)
+	c.Check(ops[0].Op, Equals, "fetch")
+	c.Check(ops[0].Duration, Equals, 2*time.Second)
+	c.Check(ops[3].Op, Equals, "fetch")
+	c.Check(ops[3].Duration, Equals, 500*time.Millisecond)
+}
+
+func (s *ProfileSuite) TestReport(c *C) {
+	var buf bytes.Buffer
+	r := report.NewText(log.New(&buf, "", 0), ioutil.Discard)
+
+	Report(r)
+	c.Check(buf.String(), Equals, "Profile: 0.000s in 0 operations on 0 dependencies\n")
+
+	record("foo", "clone", 0, 1)
+	record("foo", "checkout", 1, 0.25)
+	record("gocheck", "fetch", 0, 12)
+
+	buf.Reset()
+	Report(r)
+	c.Check(buf.String(), Equals, "Profile: 13.250s in 3 operations on 2 dependencies\n"+
+		"Slowest dependencies:\n"+
+		"  12.000s  gocheck  fetch 12.000s\n"+
+		"   1.250s  foo      clone 1.000s, checkout 0.250s\n"+
+		"Slowest operations:\n"+
+		"  12.000s  gocheck  fetch\n"+
+		"   1.000s  foo      clone\n"+
+		"   0.250s  foo      checkout\n")
+}
+
+func (s *ProfileSuite) TestWriteTrace(c *C) {
+	dir, err := ioutil.TempDir("", "DepmanUnitTest")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	record("foo", "clone", 0.5, 1)
+	record("bar", "fetch", 0, 0.25)
+	record("foo", OpRecurse, 2, 3)
+
+	file := filepath.Join(dir, "trace.json")
+	c.Assert(WriteTrace(file), IsNil)
+
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+
+	var trace struct {
+		TraceEvents []traceEvent
+	}
+	c.Assert(json.Unmarshal(data, &trace), IsNil)
+
+	events := trace.TraceEvents
+	c.Assert(events, HasLen, 5)
+	c.Check(events[0], DeepEquals, traceEvent{Name: "thread_name", Phase: "M", PID: 1, TID: 1, Args: map[string]string{"name": "foo"}})
+	c.Check(events[1].Name, Equals, "clone")
+	c.Check(events[1].Phase, Equals, "X")
+	c.Check(events[1].Time, Equals, int64(500000))
+	c.Check(events[1].Dur, Equals, int64(1000000))
+	c.Check(events[1].Args["repo"], Equals, "example.com/foo")
+
+	// one row per dependency
+	c.Check(events[3].TID, Equals, 2)
+	c.Check(events[4].Name, Equals, OpRecurse)
+	c.Check(events[4].TID, Equals, 1)
+}
@@ -0,0 +1,148 @@
+package report
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"sync"
+
+	"github.com/vube/depman/result"
+)
+
+// flushLock serializes the flushing of Buffers to their shared target
+var flushLock sync.Mutex
+
+// Live is implemented by Reporters showing what is in progress, such as Progress. A Buffer passes Queued,
+// DependencyStart and CommandStart on to a Live target as they happen instead of holding them
+type Live interface {
+	Reporter
+
+	// Live returns true if the Reporter shows what is in progress
+	Live() bool
+}
+
+// Buffer holds events until Flush is called, then passes them on to its target in order.
+// This keeps the output of dependencies installed in parallel from interleaving. A Buffer must only be used by one
+// goroutine at a time
+type Buffer struct {
+	target Reporter
+	events []func(Reporter)
+}
+
+// NewBuffer returns a Buffer for target
+func NewBuffer(target Reporter) *Buffer {
+	return &Buffer{target: target}
+}
+
+// Target returns the Reporter the Buffer is flushed to, for events which must not be held
+func (b *Buffer) Target() Reporter {
+	return b.target
+}
+
+// Flush passes the buffered events to the target
+func (b *Buffer) Flush() {
+	flushLock.Lock()
+	defer flushLock.Unlock()
+
+	for _, e := range b.events {
+		e(b.target)
+	}
+	b.events = nil
+}
+
+// add holds e until Flush
+func (b *Buffer) add(e func(Reporter)) {
+	b.events = append(b.events, e)
+}
+
+// live returns the target if it is Live
+func (b *Buffer) live() (l Live, ok bool) {
+	l, ok = b.target.(Live)
+	return l, ok && l.Live()
+}
+
+// Queued passes the Queued event on to a Live target, or holds it
+func (b *Buffer) Queued(n int) {
+	if l, ok := b.live(); ok {
+		l.Queued(n)
+		return
+	}
+	b.add(func(r Reporter) { r.Queued(n) })
+}
+
+// DependencyStart passes the DependencyStart event on to a Live target, or holds it
+func (b *Buffer) DependencyStart(d Dependency) {
+	if l, ok := b.live(); ok {
+		l.DependencyStart(d)
+		return
+	}
+	b.add(func(r Reporter) { r.DependencyStart(d) })
+}
+
+// CommandStart passes the CommandStart event on to a Live target, or holds it
+func (b *Buffer) CommandStart(c Command) {
+	if l, ok := b.live(); ok {
+		l.CommandStart(c)
+		return
+	}
+	b.add(func(r Reporter) { r.CommandStart(c) })
+}
+
+// CommandRun holds the CommandRun event
+func (b *Buffer) CommandRun(c Command) {
+	b.add(func(r Reporter) { r.CommandRun(c) })
+}
+
+// CommandFailed holds the CommandFailed event
+func (b *Buffer) CommandFailed(c Command) {
+	b.add(func(r Reporter) { r.CommandFailed(c) })
+}
+
+// Fetched holds the Fetched event
+func (b *Buffer) Fetched(d Dependency) {
+	b.add(func(r Reporter) { r.Fetched(d) })
+}
+
+// CheckedOut holds the CheckedOut event
+func (b *Buffer) CheckedOut(d Dependency) {
+	b.add(func(r Reporter) { r.CheckedOut(d) })
+}
+
+// Skipped holds the Skipped event
+func (b *Buffer) Skipped(d Dependency) {
+	b.add(func(r Reporter) { r.Skipped(d) })
+}
+
+// Info holds the Info message
+func (b *Buffer) Info(depth int, msg string) {
+	b.add(func(r Reporter) { r.Info(depth, msg) })
+}
+
+// Detail holds the Detail message
+func (b *Buffer) Detail(depth int, msg string) {
+	b.add(func(r Reporter) { r.Detail(depth, msg) })
+}
+
+// Warning holds the Warning message
+func (b *Buffer) Warning(depth int, msg string) {
+	b.add(func(r Reporter) { r.Warning(depth, msg) })
+}
+
+// Error holds the Error event
+func (b *Buffer) Error(d Dependency, e *result.Error) {
+	b.add(func(r Reporter) { r.Error(d, e) })
+}
+
+// Frozen holds the Frozen event
+func (b *Buffer) Frozen(frozen []Frozen) {
+	b.add(func(r Reporter) { r.Frozen(frozen) })
+}
+
+// Item holds the Item event
+func (b *Buffer) Item(i Item) {
+	b.add(func(r Reporter) { r.Item(i) })
+}
+
+// Summary holds the Summary event
+func (b *Buffer) Summary(s Summary) {
+	b.add(func(r Reporter) { r.Summary(s) })
+}
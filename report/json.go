@@ -0,0 +1,204 @@
+package report
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/vube/depman/result"
+)
+
+// Types of events written by JSON
+const (
+	EventStart      = "start"
+	EventCommand    = "command"
+	EventFetched    = "fetched"
+	EventCheckedOut = "checked-out"
+	EventSkipped    = "skipped-duplicate"
+	EventError      = "error"
+	EventSummary    = "summary"
+
+	// Items of doctor, cache list, evict and touch and config show
+	EventCheck        = "check"
+	EventCacheEntry   = "cache-entry"
+	EventCacheEvicted = "cache-evicted"
+	EventCacheTouched = "cache-touched"
+	EventOption       = "option"
+)
+
+// Event is a single line written by JSON
+type Event struct {
+	Event string `json:"event"`
+
+	// the dependency
+	Name    string `json:"name,omitempty"`
+	Repo    string `json:"repo,omitempty"`
+	Version string `json:"version,omitempty"`
+	Head    string `json:"head,omitempty"`
+	Stale   bool   `json:"stale"`
+
+	// seconds since the dependency was started, or the time taken by the command
+	Duration float64 `json:"duration,omitempty"`
+
+	// the command which was run, and for errors the operation which failed
+	Command string `json:"command,omitempty"`
+	Op      string `json:"op,omitempty"`
+
+	Error  string `json:"error,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// summaryEvent is the last line written by JSON
+type summaryEvent struct {
+	Event    string  `json:"event"`
+	ExitCode int     `json:"exit_code"`
+	Duration float64 `json:"duration"`
+	Errors   []Event `json:"errors"`
+}
+
+// JSON reports events as newline delimited JSON objects for other tools.
+// Messages are not events, they are passed on to the embedded Text reporter along with failed commands
+type JSON struct {
+	*Text
+
+	out  io.Writer
+	lock sync.Mutex
+}
+
+// NewJSON returns a JSON reporter writing events to out and messages to text
+func NewJSON(out io.Writer, text *Text) *JSON {
+	return &JSON{Text: text, out: out}
+}
+
+// emit writes v as a single line
+func (j *JSON) emit(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.out.Write(append(data, '\n'))
+}
+
+// event returns an event of type t about d
+func event(t string, d Dependency) Event {
+	return Event{
+		Event:    t,
+		Name:     d.Name,
+		Repo:     d.Repo,
+		Version:  d.Version,
+		Head:     d.Head,
+		Stale:    d.Stale,
+		Duration: seconds(d.Duration),
+	}
+}
+
+// DependencyStart writes a start event
+func (j *JSON) DependencyStart(d Dependency) {
+	j.Text.DependencyStart(d)
+	j.emit(event(EventStart, d))
+}
+
+// CommandRun writes a command event
+func (j *JSON) CommandRun(c Command) {
+	j.Text.CommandRun(c)
+
+	e := event(EventCommand, c.Dependency)
+	e.Command = c.Args
+	e.Duration = seconds(c.Duration)
+	if c.Err != nil {
+		e.Error = c.Err.Error()
+	}
+	j.emit(e)
+}
+
+// Fetched writes a fetched event
+func (j *JSON) Fetched(d Dependency) {
+	j.emit(event(EventFetched, d))
+}
+
+// CheckedOut writes a checked-out event
+func (j *JSON) CheckedOut(d Dependency) {
+	j.Text.CheckedOut(d)
+	j.emit(event(EventCheckedOut, d))
+}
+
+// Skipped writes a skipped-duplicate event
+func (j *JSON) Skipped(d Dependency) {
+	j.Text.Skipped(d)
+	j.emit(event(EventSkipped, d))
+}
+
+// Error writes an error event, the error is only displayed as text with the summary
+func (j *JSON) Error(d Dependency, e *result.Error) {
+	j.emit(errorEvent(d, e))
+}
+
+// Frozen writes the frozen dependencies as a single JSON array, instead of events
+func (j *JSON) Frozen(frozen []Frozen) {
+	if frozen == nil {
+		frozen = []Frozen{}
+	}
+	data, _ := json.MarshalIndent(frozen, "", "    ")
+	j.out.Write(append(data, '\n'))
+}
+
+// Item writes an event of type i.Event holding the fields of i.Value
+func (j *JSON) Item(i Item) {
+	head, err := json.Marshal(struct {
+		Event string `json:"event"`
+	}{i.Event})
+	if err != nil {
+		return
+	}
+
+	// append the fields after the type of the event, like the fields of the other events
+	fields, err := json.Marshal(i.Value)
+	if err != nil || len(fields) < 2 || fields[0] != '{' {
+		return
+	}
+	if len(fields) > 2 {
+		head = append(head[:len(head)-1], ',')
+		head = append(head, fields[1:]...)
+	}
+	j.emit(json.RawMessage(head))
+}
+
+// Summary writes the summary event
+func (j *JSON) Summary(s Summary) {
+	j.Text.Summary(s)
+
+	e := summaryEvent{
+		Event:    EventSummary,
+		ExitCode: s.ExitCode,
+		Duration: seconds(s.Duration),
+		Errors:   []Event{},
+	}
+	for _, err := range s.Errors {
+		e.Errors = append(e.Errors, errorEvent(Dependency{}, err))
+	}
+	j.emit(e)
+}
+
+// errorEvent returns the event for the error e about d
+func errorEvent(d Dependency, e *result.Error) Event {
+	ev := event(EventError, d)
+	if ev.Name == "" {
+		ev.Name = e.Dep
+	}
+	ev.Op = e.Op
+	ev.Command = e.Command
+	ev.Output = e.Output
+	ev.Error = e.Reason()
+	return ev
+}
+
+// seconds returns d in seconds, rounded to milliseconds
+func seconds(d time.Duration) float64 {
+	return float64(d/time.Millisecond) / 1000
+}
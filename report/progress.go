@@ -0,0 +1,202 @@
+package report
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vube/depman/result"
+)
+
+// clearLine moves to the start of the line and erases it
+const clearLine = "\r\033[K"
+
+// Progress reports to a terminal: instead of a line for each dependency it keeps a single line at the bottom showing
+// the dependency being installed, how many have been started out of those queued, the command running and the time
+// elapsed, redrawn every second. Messages are displayed above it by the embedded Text reporter, whose Log must
+// write to the Progress
+type Progress struct {
+	*Text
+
+	w     io.Writer
+	width int
+	start time.Time
+
+	lock    sync.Mutex
+	started int
+	total   int
+	name    string
+	command string
+
+	// whether the line is on the terminal, and the dependency whose heading was displayed last
+	drawn bool
+	shown string
+
+	ticker  *time.Ticker
+	done    chan struct{}
+	stopped bool
+}
+
+// NewProgress returns a Progress drawing its line on w, a terminal width columns wide, and messages with text
+func NewProgress(text *Text, w io.Writer, width int) *Progress {
+	return &Progress{Text: text, w: w, width: width, start: time.Now()}
+}
+
+// Write writes b above the line, it is the output of the Log of the Text reporter
+func (p *Progress) Write(b []byte) (n int, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.clear()
+	n, err = p.w.Write(b)
+	p.draw()
+	return
+}
+
+// line returns the text of the line
+func (p *Progress) line() string {
+	count := fmt.Sprintf("[%d/%d]", p.started, p.total)
+	if p.total < p.started {
+		count = fmt.Sprintf("[%d]", p.started)
+	}
+
+	line := count + " " + p.name
+	if p.command != "" {
+		line += ": " + p.command
+	}
+	line += fmt.Sprintf(" (%s)", time.Since(p.start)/time.Second*time.Second)
+
+	// the cursor stays on the line, writing in the last column would wrap it
+	if r := []rune(line); p.width > 1 && len(r) >= p.width {
+		line = string(r[:p.width-1])
+	}
+	return line
+}
+
+// draw writes the line once a dependency has been started
+func (p *Progress) draw() {
+	if p.started == 0 || p.stopped {
+		return
+	}
+	io.WriteString(p.w, clearLine+p.line())
+	p.drawn = true
+}
+
+// clear erases the line
+func (p *Progress) clear() {
+	if p.drawn {
+		io.WriteString(p.w, clearLine)
+		p.drawn = false
+	}
+}
+
+// tick redraws the line every second to update the time elapsed
+func (p *Progress) tick() {
+	for {
+		select {
+		case <-p.ticker.C:
+			p.lock.Lock()
+			p.draw()
+			p.lock.Unlock()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Stop erases the line, messages written afterwards are displayed as they are
+func (p *Progress) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.ticker != nil && !p.stopped {
+		p.ticker.Stop()
+		close(p.done)
+	}
+	p.clear()
+	p.stopped = true
+}
+
+// Live returns true, the line shows what is in progress
+func (p *Progress) Live() bool {
+	return true
+}
+
+// Queued adds n to the number of dependencies to install
+func (p *Progress) Queued(n int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.total += n
+}
+
+// DependencyStart displays d on the line
+func (p *Progress) DependencyStart(d Dependency) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.started++
+	p.name = d.Name
+	p.command = ""
+	if p.ticker == nil && !p.stopped {
+		p.ticker = time.NewTicker(time.Second)
+		p.done = make(chan struct{})
+		go p.tick()
+	}
+	p.draw()
+}
+
+// CommandStart displays the command on the line, without its arguments, queries are not displayed
+func (p *Progress) CommandStart(c Command) {
+	if c.Query {
+		return
+	}
+
+	words := strings.Fields(c.Args)
+	if len(words) > 2 {
+		words = words[:2]
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.command = strings.Join(words, " ")
+	p.draw()
+}
+
+// heading displays the dependency a failure is about, since dependencies are not displayed as they are started
+func (p *Progress) heading(d Dependency) {
+	p.lock.Lock()
+	show := d.Name != "" && d.Name != p.shown
+	if show {
+		p.shown = d.Name
+	}
+	p.lock.Unlock()
+
+	// written through Write, which takes the lock
+	if show {
+		p.Text.DependencyStart(d)
+	}
+}
+
+// CommandFailed displays the dependency, then the command and its output
+func (p *Progress) CommandFailed(c Command) {
+	p.heading(c.Dependency)
+	p.Text.CommandFailed(c)
+}
+
+// Error displays the dependency, then the error
+func (p *Progress) Error(d Dependency, e *result.Error) {
+	if e.Command == "" {
+		p.heading(d)
+	}
+	p.Text.Error(d, e)
+}
+
+// Summary erases the line, then displays the summary
+func (p *Progress) Summary(s Summary) {
+	p.Stop()
+	p.Text.Summary(s)
+}
@@ -0,0 +1,121 @@
+// Package report defines how the progress and results of a run are reported.
+// A Reporter is passed to the code doing the work, which describes what happens as events, the Reporter decides how
+// (and whether) to display them: Text for humans, Progress for humans at a terminal, JSON for other tools, Quiet for
+// scripts, Buffer to hold the output of dependencies installed in parallel
+package report
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"time"
+
+	"github.com/vube/depman/result"
+)
+
+// NoIndent is the depth of messages which are not about a dependency, they are not indented
+const NoIndent = -1
+
+// Dependency describes the dependency an event is about
+type Dependency struct {
+	Name    string
+	Repo    string
+	Version string
+	Stale   bool
+
+	// the resolved revision, once it is known
+	Head string
+
+	// how deeply the dependency is nested in the tree, 0 for the dependencies in the top level deps.json
+	Depth int
+
+	// the time since the dependency was started
+	Duration time.Duration
+}
+
+// Command describes a command which was run for a dependency
+type Command struct {
+	Dependency Dependency
+
+	// the command line, quoted for display
+	Args string
+
+	Output   string
+	Err      error
+	Duration time.Duration
+
+	// a query of the state of a repository, rather than a command which changes it
+	Query bool
+}
+
+// Frozen is a dependency resolved to a commit ID
+type Frozen struct {
+	Name    string `json:"name"`
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
+}
+
+// Item is an entry of the result of a command which lists things, e.g. a check of doctor or an entry of the cache
+type Item struct {
+	// the type of the event written by JSON, with the fields of Value, a struct with json tags
+	Event string
+	Value interface{}
+
+	// the lines displayed by Text, at Depth
+	Lines []string
+	Depth int
+}
+
+// Summary is the result of a run
+type Summary struct {
+	ExitCode int
+	Duration time.Duration
+	Errors   result.Errors
+}
+
+// Reporter receives the events of a run
+type Reporter interface {
+	// Queued is called with the number of dependencies in a deps.json which are about to be installed
+	Queued(n int)
+
+	// DependencyStart is called before a dependency is installed
+	DependencyStart(d Dependency)
+
+	// CommandStart is called before each command, c only has the dependency and the command line
+	CommandStart(c Command)
+
+	// CommandRun is called after each command, whether or not it succeeded
+	CommandRun(c Command)
+
+	// CommandFailed is called when a failed command is reported as an error, after CommandRun
+	CommandFailed(c Command)
+
+	// Fetched is called after a dependency was fetched
+	Fetched(d Dependency)
+
+	// CheckedOut is called after a dependency was checked out, d.Head is set if it is known
+	CheckedOut(d Dependency)
+
+	// Skipped is called for a dependency which was already installed elsewhere in the tree
+	Skipped(d Dependency)
+
+	// Info is a normal message
+	Info(depth int, msg string)
+
+	// Detail is a message only meant for --verbose
+	Detail(depth int, msg string)
+
+	// Warning is a problem which does not stop the run
+	Warning(depth int, msg string)
+
+	// Error is called when a dependency fails, or for an error which is not about a dependency with an empty d
+	Error(d Dependency, e *result.Error)
+
+	// Frozen is the result of show-frozen
+	Frozen(frozen []Frozen)
+
+	// Item is an entry of the result of a command which lists things, such as doctor, cache list and config show
+	Item(i Item)
+
+	// Summary is called once at the end of the run
+	Summary(s Summary)
+}
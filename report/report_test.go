@@ -0,0 +1,185 @@
+package report
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	. "launchpad.net/gocheck"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/result"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ReportSuite struct {
+	buf *bytes.Buffer
+	out *bytes.Buffer
+	t   *Text
+}
+
+var _ = Suite(&ReportSuite{})
+
+func (s *ReportSuite) SetUpTest(c *C) {
+	colors.Mock()
+	s.buf = new(bytes.Buffer)
+	s.out = new(bytes.Buffer)
+	s.t = NewText(log.New(s.buf, "", 0), s.out)
+}
+
+func (s *ReportSuite) TestText(c *C) {
+	d := Dependency{Name: "foo", Repo: "example.com/foo", Version: "master", Stale: true, Depth: 1}
+
+	s.t.DependencyStart(d)
+	s.t.CommandStart(Command{Dependency: d, Args: "git fetch"})
+	s.t.Detail(1, "hidden")
+	s.t.Info(NoIndent, "Installing:")
+	c.Check(s.buf.String(), Equals, " | | foo (master) *\nInstalling:\n")
+
+	s.buf.Reset()
+	s.t.Verbose = true
+	s.t.DependencyStart(d)
+	s.t.CommandStart(Command{Dependency: d, Args: "git fetch"})
+	s.t.CommandStart(Command{Dependency: d, Args: "git rev-parse HEAD", Query: true})
+	c.Check(s.buf.String(), Equals, " | | foo (master) example.com/foo *\n | | $ git fetch\n")
+
+	s.t.Frozen([]Frozen{{Name: "foo", Repo: "example.com/foo", Version: "abc"}})
+	c.Check(s.out.String(), Equals, "example.com/foo abc\n")
+}
+
+func (s *ReportSuite) TestQuiet(c *C) {
+	q := NewQuiet(log.New(s.buf, "", 0), s.out)
+	q.Verbose = true
+	d := Dependency{Name: "foo", Version: "master"}
+
+	q.DependencyStart(d)
+	q.Info(0, "info")
+	q.Warning(0, "warning")
+	c.Check(s.buf.String(), Equals, "")
+
+	q.Error(d, &result.Error{Op: "lock", Err: errors.New("timed out")})
+	q.Error(Dependency{}, &result.Error{Dep: "bar", Op: "read", Err: errors.New("bad json")})
+	c.Check(s.buf.String(), Equals, " | lock: timed out\nbar: read: bad json\n")
+}
+
+func (s *ReportSuite) TestBuffer(c *C) {
+	a := NewBuffer(s.t)
+	b := NewBuffer(s.t)
+
+	a.Info(0, "a1")
+	b.Info(0, "b1")
+	a.Info(0, "a2")
+	c.Check(s.buf.String(), Equals, "")
+
+	b.Flush()
+	a.Flush()
+	c.Check(s.buf.String(), Equals, " | b1\n | a1\n | a2\n")
+
+	// events are only passed on once
+	a.Flush()
+	c.Check(strings.Count(s.buf.String(), "a1"), Equals, 1)
+}
+
+func (s *ReportSuite) TestBufferLive(c *C) {
+	var term bytes.Buffer
+	p := NewProgress(s.t, &term, 30)
+	s.t.Log = log.New(p, "", 0)
+	d := Dependency{Name: "foo", Repo: "example.com/foo", Version: "master"}
+
+	// the line is updated as dependencies and commands start, messages wait for Flush
+	b := NewBuffer(p)
+	b.Queued(1)
+	b.DependencyStart(d)
+	b.CommandStart(Command{Dependency: d, Args: "git fetch --tags origin"})
+	b.Info(0, "fetched")
+	c.Check(term.String(), Equals, clearLine+"[1/1] foo (0s)"+clearLine+"[1/1] foo: git fetch (0s)")
+
+	term.Reset()
+	b.Flush()
+	c.Check(term.String(), Equals, clearLine+" | fetched\n"+clearLine+"[1/1] foo: git fetch (0s)")
+	p.Stop()
+}
+
+func (s *ReportSuite) TestJSON(c *C) {
+	var events bytes.Buffer
+	j := NewJSON(&events, s.t)
+	d := Dependency{Name: "foo", Repo: "example.com/foo", Version: "master"}
+
+	j.DependencyStart(d)
+	j.Error(d, &result.Error{Dep: "foo", Op: "fetch", Command: "git fetch", Output: "fatal: nope", Err: errors.New("exit status 128")})
+	j.Summary(Summary{ExitCode: 4})
+
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	c.Assert(lines, HasLen, 3)
+
+	var e Event
+	c.Assert(json.Unmarshal([]byte(lines[1]), &e), IsNil)
+	c.Check(e.Event, Equals, EventError)
+	c.Check(e.Name, Equals, "foo")
+	c.Check(e.Op, Equals, "fetch")
+	c.Check(e.Error, Equals, "fatal: nope")
+
+	c.Check(lines[2], Equals, `{"event":"summary","exit_code":4,"duration":0,"errors":[]}`)
+
+	// text messages are still displayed
+	c.Check(s.buf.String(), Equals, " | foo (master)\n")
+}
+
+func (s *ReportSuite) TestItem(c *C) {
+	type check struct {
+		Name   string `json:"name"`
+		Detail string `json:"detail,omitempty"`
+	}
+
+	s.t.Item(Item{Event: EventCheck, Value: check{Name: "git"}, Lines: []string{"ok git", "  fix: nothing"}, Depth: NoIndent})
+	c.Check(s.out.String(), Equals, "ok git\n  fix: nothing\n")
+
+	var events bytes.Buffer
+	j := NewJSON(&events, s.t)
+	j.Item(Item{Event: EventCheck, Value: check{Name: "git", Detail: "2.1"}, Lines: []string{"ok git"}})
+	j.Item(Item{Event: EventCheck, Value: struct{}{}})
+	c.Check(events.String(), Equals, `{"event":"check","name":"git","detail":"2.1"}`+"\n"+`{"event":"check"}`+"\n")
+}
+
+func (s *ReportSuite) TestProgress(c *C) {
+	var term bytes.Buffer
+	p := NewProgress(s.t, &term, 30)
+	s.t.Log = log.New(p, "", 0)
+	d := Dependency{Name: "foo", Repo: "example.com/foo", Version: "master"}
+
+	// nothing is drawn until a dependency is started
+	p.Queued(2)
+	p.Info(NoIndent, "Installing:")
+	c.Check(term.String(), Equals, "Installing:\n")
+
+	term.Reset()
+	p.DependencyStart(d)
+	p.CommandStart(Command{Dependency: d, Args: "git rev-parse HEAD", Query: true})
+	p.CommandStart(Command{Dependency: d, Args: "git fetch --tags origin"})
+	c.Check(term.String(), Equals, clearLine+"[1/2] foo (0s)"+clearLine+"[1/2] foo: git fetch (0s)")
+
+	// messages are written above the line, failures under the dependency they are about
+	term.Reset()
+	p.CommandFailed(Command{Dependency: d, Args: "git checkout v2", Output: "error: no such ref"})
+	c.Check(term.String(), Equals, clearLine+" | foo (master)\n"+clearLine+"[1/2] foo: git fetch (0s)"+
+		clearLine+" | $ git checkout v2\n"+clearLine+"[1/2] foo: git fetch (0s)"+
+		clearLine+" | error: no such ref\n"+clearLine+"[1/2] foo: git fetch (0s)")
+
+	// the line is cut to the width of the terminal, and counts dependencies which were not queued
+	p.name = "github.com/example/a-long-name"
+	p.started = 3
+	c.Check(p.line(), Equals, "[3] github.com/example/a-long")
+
+	term.Reset()
+	p.Summary(Summary{})
+	c.Check(term.String(), Equals, clearLine+"Success\n")
+}
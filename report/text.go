@@ -0,0 +1,172 @@
+package report
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/result"
+)
+
+// Text reports events as colored text for humans
+type Text struct {
+	// Log receives messages, Out receives results (show-frozen and Items)
+	Log *log.Logger
+	Out io.Writer
+
+	// Verbose displays commands and informative messages, Debug also displays the output of every command,
+	// Silent only displays errors
+	Verbose bool
+	Debug   bool
+	Silent  bool
+
+	// Indent returns the prefix for messages at depth
+	Indent func(depth int) string
+}
+
+// NewText returns a Text reporter writing messages to l and results to out
+func NewText(l *log.Logger, out io.Writer) *Text {
+	return &Text{Log: l, Out: out, Indent: DefaultIndent}
+}
+
+// NewQuiet returns a Text reporter which only displays errors
+func NewQuiet(l *log.Logger, out io.Writer) *Text {
+	t := NewText(l, out)
+	t.Silent = true
+	return t
+}
+
+// DefaultIndent indents each level of the tree of dependencies with " |"
+func DefaultIndent(depth int) string {
+	if depth == NoIndent {
+		return ""
+	}
+	return strings.Repeat(" |", depth+1) + " "
+}
+
+// print writes msg at depth
+func (t *Text) print(depth int, msg string) {
+	t.Log.Output(3, t.Indent(depth)+msg)
+}
+
+// Queued displays nothing
+func (t *Text) Queued(n int) {}
+
+// DependencyStart displays the dependency, the repo is only included with Verbose, stale dependencies are marked with '*'
+func (t *Text) DependencyStart(d Dependency) {
+	if t.Silent {
+		return
+	}
+
+	line := colors.Blue(d.Name) + colors.Yellow(" ("+d.Version+")")
+	if t.Verbose {
+		line += " " + d.Repo
+	}
+	if d.Stale {
+		line += " *"
+	}
+	t.print(d.Depth, line)
+}
+
+// CommandStart displays the command with Verbose, or with Debug for queries
+func (t *Text) CommandStart(c Command) {
+	if c.Query && t.Debug || !c.Query && t.Verbose {
+		t.print(c.Dependency.Depth, "$ "+c.Args)
+	}
+}
+
+// CommandRun displays the output of the command with Debug
+func (t *Text) CommandRun(c Command) {
+	if len(c.Output) > 0 && t.Debug {
+		t.print(c.Dependency.Depth, c.Output)
+	}
+}
+
+// CommandFailed displays the command and its output in red, even when Silent
+func (t *Text) CommandFailed(c Command) {
+	t.print(c.Dependency.Depth, colors.Red("$ "+c.Args))
+	t.print(c.Dependency.Depth, colors.Red(strings.TrimRight(c.Output, "\n")))
+}
+
+// Fetched displays nothing, the dependency is already marked as stale
+func (t *Text) Fetched(d Dependency) {}
+
+// CheckedOut displays the time taken to install the dependency with Verbose
+func (t *Text) CheckedOut(d Dependency) {
+	t.Detail(d.Depth, fmt.Sprintf("# time to install: %.3fs", d.Duration.Seconds()))
+}
+
+// Skipped displays the skipped dependency with Verbose
+func (t *Text) Skipped(d Dependency) {
+	t.Detail(d.Depth, colors.Yellow("Skipping previously installed dependency: ")+d.Repo)
+}
+
+// Info displays msg unless Silent
+func (t *Text) Info(depth int, msg string) {
+	if !t.Silent {
+		t.print(depth, msg)
+	}
+}
+
+// Detail displays msg with Verbose
+func (t *Text) Detail(depth int, msg string) {
+	if t.Verbose && !t.Silent {
+		t.print(depth, msg)
+	}
+}
+
+// Warning displays msg in yellow unless Silent
+func (t *Text) Warning(depth int, msg string) {
+	if !t.Silent {
+		t.print(depth, colors.Yellow(msg))
+	}
+}
+
+// Error displays errors which did not come from a command in red, even when Silent.
+// The errors of commands were already displayed by CommandFailed
+func (t *Text) Error(d Dependency, e *result.Error) {
+	if e.Command != "" {
+		return
+	}
+
+	// errors about a dependency are displayed under it, others name the dependency
+	if d.Name == "" {
+		t.print(NoIndent, colors.Red(e.Error()))
+		return
+	}
+
+	msg := e.Err.Error()
+	if e.Op != "" {
+		msg = e.Op + ": " + msg
+	}
+	t.print(d.Depth, colors.Red(msg))
+}
+
+// Frozen writes each dependency as "repo version" to Out
+func (t *Text) Frozen(frozen []Frozen) {
+	for _, f := range frozen {
+		fmt.Fprintf(t.Out, "%s %s\n", f.Repo, f.Version)
+	}
+}
+
+// Item writes the lines of i to Out
+func (t *Text) Item(i Item) {
+	for _, l := range i.Lines {
+		fmt.Fprintln(t.Out, t.Indent(i.Depth)+l)
+	}
+}
+
+// Summary displays a table of the errors, or "Success"
+func (t *Text) Summary(s Summary) {
+	switch {
+	case s.ExitCode == 0:
+		t.Info(NoIndent, "Success")
+	case len(s.Errors) > 0:
+		t.print(NoIndent, colors.Red("Failures:"))
+		t.print(NoIndent, strings.TrimSuffix(s.Errors.Table(), "\n"))
+	}
+}
@@ -1,16 +1,234 @@
-// Package result hold a "global" result boolean
+// Package result holds the "global" result of a run
 // This allows the application to eventually exit non-zero
-// but only after completing a task where a portion of the task returned errors
+// but only after completing a task where a portion of the task returned errors.
+// Errors can be registered with details (see Error), which are listed by Summary at the end of the run,
+// and with a Kind, which determines the exit code (see ExitCode)
 package result
 
-var err bool
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Kind is the category of an error, its value is the exit code used for it
+type Kind int
+
+// Kinds of errors, in order of precedence
+const (
+	// Other is any error without a more specific kind
+	Other Kind = 1
+
+	// Config is an invalid command line or deps.json
+	Config Kind = 2
+
+	// Conflict is a dependency required at two different versions
+	Conflict Kind = 3
+
+	// VCS is a failed VCS command, including network failures
+	VCS Kind = 4
+
+	// Dirty is a VCS command refused because of local changes in a dependency
+	Dirty Kind = 5
+
+	// Verify is a dependency which is not at the pinned revision after it was checked out
+	Verify Kind = 6
+
+	// Offline is something which requires network access with --offline, such as a dependency which is not present
+	// locally
+	Offline Kind = 7
+)
+
+// Error is a failure which occurred during the run, any of the fields describing it may be empty
+type Error struct {
+	// the category of the error, Other if it is not set
+	Kind Kind
+
+	// the nickname of the dependency
+	Dep string
+
+	// the operation which failed (clone, fetch, checkout, update...)
+	Op string
+
+	// the command which failed and its output
+	Command string
+	Output  string
+
+	// the deps.json which declares the dependency
+	DepsFile string
+
+	// the underlying error
+	Err error
+}
+
+// Error formats the error on one line, prefixed by the dependency and operation if they are known
+func (e *Error) Error() string {
+	var parts []string
+	for _, p := range []string{e.Dep, e.Op} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if e.Err != nil {
+		parts = append(parts, e.Err.Error())
+	}
+	return strings.Join(parts, ": ")
+}
+
+// Reason returns a short description of the cause of e, the last line of the output if there is one
+func (e *Error) Reason() string {
+	lines := strings.Split(strings.TrimSpace(e.Output), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return last
+	}
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return ""
+}
+
+// Errors is a list of errors, returned as a single error
+type Errors []*Error
+
+// Error formats the errors on one line each
+func (errs Errors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	err bool
+
+	// the errors registered with details
+	errs Errors
+
+	// the number of errors registered, with or without details
+	count int
+
+	// guards err and errs, errors may be registered by concurrent installs
+	lock sync.Mutex
+)
 
 // RegisterError should be called when a non-fatal error occurred, exicution should continue but we want to exit non-zero eventually
 func RegisterError() {
+	lock.Lock()
+	defer lock.Unlock()
 	err = true
+	count++
+}
+
+// Register is RegisterError with the details of the error, which are included in the Summary.
+// The fields of e may be filled in after it is registered, as it is passed up to code which knows more about it
+func Register(e *Error) {
+	lock.Lock()
+	defer lock.Unlock()
+	err = true
+	count++
+	errs = append(errs, e)
 }
 
 // ShouldExitWithError can be called to determine if the application should exit non-zero
 func ShouldExitWithError() bool {
+	lock.Lock()
+	defer lock.Unlock()
 	return err
 }
+
+// ExitCode returns the code the application should exit with, 0 if no errors were registered.
+// If errors of several kinds were registered the kind with the lowest code wins, Other is only used if there is no
+// more specific kind
+func ExitCode() int {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !err {
+		return 0
+	}
+	return exitCode(errs)
+}
+
+// exitCode returns the code for errs, at least one error having been registered
+func exitCode(errs Errors) int {
+	code := Other
+	for _, e := range errs {
+		if e.Kind > Other && (code == Other || e.Kind < code) {
+			code = e.Kind
+		}
+	}
+	return int(code)
+}
+
+// Mark is the position of a run in the errors registered, see Since
+type Mark struct {
+	count int
+	errs  int
+}
+
+// Start returns the Mark of a run starting now
+func Start() Mark {
+	lock.Lock()
+	defer lock.Unlock()
+	return Mark{count: count, errs: len(errs)}
+}
+
+// Since returns the exit code and the errors of the run started at m, errors registered before it are left out
+func Since(m Mark) (code int, all Errors) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	all = append(Errors(nil), errs[m.errs:]...)
+	if count == m.count {
+		return 0, all
+	}
+	return exitCode(all), all
+}
+
+// All returns the errors registered with details
+func All() Errors {
+	lock.Lock()
+	defer lock.Unlock()
+	return append(Errors(nil), errs...)
+}
+
+// Summary returns a table of the errors registered with details, or the empty string if there are none
+func Summary() string {
+	return All().Table()
+}
+
+// Table returns a table of the errors, or the empty string if there are none
+func (errs Errors) Table() string {
+	if len(errs) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEPENDENCY\tOPERATION\tDEPS.JSON\tCOMMAND\tERROR")
+	for _, e := range errs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", dash(e.Dep), dash(e.Op), dash(e.DepsFile), dash(e.Command), dash(e.Reason()))
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// dash returns "-" for empty table cells
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Reset forgets all errors, for testing
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	err = false
+	count = 0
+	errs = nil
+}
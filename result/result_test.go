@@ -0,0 +1,82 @@
+package result
+
+import (
+	"errors"
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func TestResult(t *testing.T) {
+	TestingT(t)
+}
+
+type ResultSuite struct{}
+
+var _ = Suite(&ResultSuite{})
+
+func (s *ResultSuite) TearDownTest(c *C) {
+	Reset()
+}
+
+func (s *ResultSuite) TestSummary(c *C) {
+	c.Check(ShouldExitWithError(), Equals, false)
+	c.Check(Summary(), Equals, "")
+
+	Register(&Error{Dep: "foo", Op: "fetch", Command: "git fetch origin", Output: "remote: hello\nfatal: could not read", DepsFile: "deps.json", Err: errors.New("exit status 128")})
+	Register(&Error{Op: "lock", Err: errors.New("timed out")})
+
+	c.Check(ShouldExitWithError(), Equals, true)
+	c.Check(All(), HasLen, 2)
+	c.Check(Summary(), Equals, ""+
+		"DEPENDENCY  OPERATION  DEPS.JSON  COMMAND           ERROR\n"+
+		"foo         fetch      deps.json  git fetch origin  fatal: could not read\n"+
+		"-           lock       -          -                 timed out\n")
+}
+
+func (s *ResultSuite) TestRegisterError(c *C) {
+	RegisterError()
+	c.Check(ShouldExitWithError(), Equals, true)
+	c.Check(Summary(), Equals, "")
+}
+
+func (s *ResultSuite) TestExitCode(c *C) {
+	c.Check(ExitCode(), Equals, 0)
+
+	RegisterError()
+	c.Check(ExitCode(), Equals, 1)
+
+	Register(&Error{Kind: Dirty, Err: errors.New("local changes")})
+	c.Check(ExitCode(), Equals, 5)
+
+	Register(&Error{Kind: VCS, Err: errors.New("could not resolve host")})
+	Register(&Error{Err: errors.New("other")})
+	c.Check(ExitCode(), Equals, 4)
+
+	Register(&Error{Kind: Config, Err: errors.New("invalid deps.json")})
+	c.Check(ExitCode(), Equals, 2)
+}
+
+func (s *ResultSuite) TestSince(c *C) {
+	Register(&Error{Kind: Config, Err: errors.New("invalid flag")})
+
+	m := Start()
+	code, errs := Since(m)
+	c.Check(code, Equals, 0)
+	c.Check(errs, HasLen, 0)
+
+	RegisterError()
+	code, _ = Since(m)
+	c.Check(code, Equals, 1)
+
+	Register(&Error{Kind: VCS, Err: errors.New("could not resolve host")})
+	code, errs = Since(m)
+	c.Check(code, Equals, 4)
+	c.Assert(errs, HasLen, 1)
+	c.Check(errs[0].Kind, Equals, VCS)
+
+	// earlier errors are kept
+	c.Check(ExitCode(), Equals, 2)
+	c.Check(All(), HasLen, 2)
+}
@@ -0,0 +1,140 @@
+package main
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vube/depman/api"
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/config"
+	"github.com/vube/depman/dep"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+	"github.com/vube/depman/util"
+)
+
+// set by the update-check setting
+var updateCheck bool
+
+// the global flags given on the command line, rather than set by configuration
+var commandLine = make(map[string]bool)
+
+// settings holds the options which have no flag, they can only be set by configuration
+var settings = flag.NewFlagSet("settings", flag.ContinueOnError)
+
+func init() {
+	settings.BoolVar(&updateCheck, "update-check", true, "Check for a new version of depman on each run")
+}
+
+// optionSets returns the flag sets holding the options of depman: the global flags, the settings, and the flags of
+// each command bound to default options
+func optionSets() []*flag.FlagSet {
+	sets := []*flag.FlagSet{flag.CommandLine, settings}
+
+	var add func(list []*command)
+	add = func(list []*command) {
+		for _, c := range list {
+			if c.flags != nil {
+				o := api.DefaultOptions()
+				sets = append(sets, c.flagSet(&o, ioutil.Discard))
+			}
+			add(c.subs)
+		}
+	}
+	add(commands)
+	return sets
+}
+
+// knownOption returns true if name is an option of depman
+func knownOption(name string) bool {
+	for _, fs := range optionSets() {
+		if fs.Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig loads the configuration of the project with the deps.json at path, and applies it to the global flags
+// and the settings
+func loadConfig(path string) *config.Config {
+	flag.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = true
+	})
+
+	dir, err := filepath.Abs(filepath.Dir(dep.GetPath(path)))
+	if err != nil {
+		util.Abort(result.Config, "Error reading the configuration: "+err.Error())
+	}
+
+	cfg, err := config.Load(dir, knownOption)
+	if err == nil {
+		for _, s := range cfg.Unknown() {
+			err = fmt.Errorf("%s: unknown option '%s'", s.Source, s.Name)
+			break
+		}
+	}
+	if err == nil {
+		err = cfg.Apply(flag.CommandLine)
+	}
+	if err == nil {
+		err = cfg.Apply(settings)
+	}
+	if err != nil {
+		util.Abort(result.Config, "Error reading the configuration: "+err.Error())
+	}
+	return cfg
+}
+
+// showConfig reports the effective value of each option, and where it was set
+func showConfig(s *session) {
+	var names []string
+	values := make(map[string]config.Setting)
+
+	for _, fs := range optionSets() {
+		// the flags of commands have not been parsed, the global flags and the settings are already configured
+		if fs != flag.CommandLine && fs != settings {
+			s.cfg.Apply(fs)
+		}
+
+		fs.VisitAll(func(f *flag.Flag) {
+			if _, ok := values[f.Name]; ok || config.Ignored[f.Name] {
+				return
+			}
+
+			v := config.Setting{Name: f.Name, Value: f.Value.String(), Source: config.SourceDefault}
+			if fs == flag.CommandLine && commandLine[f.Name] {
+				v.Source = config.SourceCommandLine
+			} else if c, ok := s.cfg.Get(f.Name); ok {
+				v.Source = c.Source
+			} else if f.Name == "no-colors" && colors.Detected() != "" {
+				v.Source = colors.Detected()
+			}
+			values[f.Name] = v
+			names = append(names, f.Name)
+		})
+	}
+	sort.Strings(names)
+
+	// the columns are aligned across the options
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		v := values[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, v.Value, v.Source)
+	}
+	w.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, name := range names {
+		s.r.Item(report.Item{Event: report.EventOption, Value: values[name], Lines: lines[i : i+1], Depth: report.NoIndent})
+	}
+}
@@ -2,47 +2,44 @@ package showfrozen
 
 // Copyright 2013-2014 Vubeology, Inc.
 
-import "fmt"
-
 import "github.com/vube/depman/dep"
+import "github.com/vube/depman/report"
+import "github.com/vube/depman/result"
 import "github.com/vube/depman/util"
 import "github.com/vube/depman/colors"
 
-//Read - get top-level frozen dependencies
-func Read(deps dep.DependencyMap) (result string) {
-	var err error
+//Freeze - get top-level frozen dependencies, messages are reported to r
+func Freeze(deps dep.DependencyMap, r report.Reporter) (frozen []report.Frozen, err error) {
 	var resultMap = make(map[string]*dep.Dependency)
 
-	util.Print(colors.Yellow("NOTE: This will not reflect the state of the remote unless you have just run `depman install`."))
+	r.Warning(report.NoIndent, "NOTE: This will not reflect the state of the remote unless you have just run `depman install`.")
 
 	for k, v := range deps.Map {
 		if v.Type == dep.TypeGitClone && v.Alias == "" {
-			util.PrintIndent(colors.Red("Error: Repo '" + k + "' Type '" + v.Type + "' requires 'alias' field (defined in " + deps.Path + ")"))
+			r.Info(0, colors.Red("Error: Repo '"+k+"' Type '"+v.Type+"' requires 'alias' field (defined in "+deps.Path+")"))
 			continue
 		}
 
 		v.Version, err = v.VCS.GetHead(v)
 		if err != nil {
-			util.Fatal(err)
+			return nil, failed(k, v.Version, err, r)
 		}
 
 		resultMap[k] = v
 	}
 
 	//not changing the logic in the loop because we might want to change the print format later
-	for _, v := range resultMap {
-		result += fmt.Sprintf("%s %s\n", v.Repo, v.Version)
+	for k, v := range resultMap {
+		frozen = append(frozen, report.Frozen{Name: k, Repo: v.Repo, Version: v.Version})
 	}
 
 	return
 }
 
-//ReadRecursively - get frozen dependencies recursively
-func ReadRecursively(deps dep.DependencyMap, set map[string]string) (result string) {
-	var err error
-
+//FreezeRecursively - get frozen dependencies recursively, messages are reported to r
+func FreezeRecursively(deps dep.DependencyMap, set map[string]string, r report.Reporter) (frozen []report.Frozen, err error) {
 	if set == nil {
-		util.Print(colors.Yellow("NOTE: This will not reflect the state of the remote unless you have just run `depman install`."))
+		r.Warning(report.NoIndent, "NOTE: This will not reflect the state of the remote unless you have just run `depman install`.")
 
 		set = make(map[string]string)
 	}
@@ -57,7 +54,7 @@ func ReadRecursively(deps dep.DependencyMap, set map[string]string) (result stri
 		}
 
 		if d.Type == dep.TypeGitClone && d.Alias == "" {
-			util.PrintIndent(colors.Red("Error: Repo '" + name + "' Type '" + d.Type + "' requires 'alias' field (defined in " + deps.Path + ")"))
+			r.Info(0, colors.Red("Error: Repo '"+name+"' Type '"+d.Type+"' requires 'alias' field (defined in "+deps.Path+")"))
 			continue
 		}
 
@@ -66,11 +63,11 @@ func ReadRecursively(deps dep.DependencyMap, set map[string]string) (result stri
 
 			temp, err = d.VCS.GetHead(d)
 			if err != nil {
-				util.Fatal(err)
+				return nil, failed(name, temp, err, r)
 			}
 
 			set[d.Repo] = temp
-			result += fmt.Sprintf("%s %s\n", d.Repo, temp)
+			frozen = append(frozen, report.Frozen{Name: name, Repo: d.Repo, Version: temp})
 		}
 
 		subPath = d.Path()
@@ -78,13 +75,29 @@ func ReadRecursively(deps dep.DependencyMap, set map[string]string) (result stri
 		// Recursive
 		depsFile = util.UpwardFind(subPath, dep.DepsFile)
 		if depsFile != "" {
-			subDeps, err = dep.Read(depsFile)
-			if err == nil {
-				result += ReadRecursively(subDeps, set)
-			} else {
-				util.Print(colors.Yellow("Error reading deps from '" + subDeps.Path + "': " + err.Error()))
+			var sub []report.Frozen
+
+			subDeps, err = dep.Read(depsFile, r)
+			if err != nil {
+				r.Warning(report.NoIndent, "Error reading deps from '"+subDeps.Path+"': "+err.Error())
+				err = nil
+				continue
 			}
+
+			sub, err = FreezeRecursively(subDeps, set, r)
+			if err != nil {
+				return nil, err
+			}
+			frozen = append(frozen, sub...)
 		}
 	}
 	return
 }
+
+//failed - register and report the failure to resolve the dependency name, out is the output of the VCS
+func failed(name string, out string, err error, r report.Reporter) *result.Error {
+	e := &result.Error{Kind: result.VCS, Dep: name, Op: "freeze", Output: out, Err: err}
+	result.Register(e)
+	r.Error(report.Dependency{}, e)
+	return e
+}
@@ -1,18 +1,23 @@
 // Package timelock implements a time based cache for dependencies
+// Entries are keyed by repo and install path, and expire after SetTTL (--cache-ttl),
+// which can be overridden per dependency with the "cache-ttl" field
 package timelock
 
 import (
 	"bytes"
 	"encoding/json"
-	"flag"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/vube/depman/colors"
 	"github.com/vube/depman/dep"
+	"github.com/vube/depman/flock"
+	"github.com/vube/depman/report"
 	"github.com/vube/depman/util"
 )
 
@@ -20,119 +25,406 @@ const (
 	// the filename of the cache json
 	cacheFileName = ".depman.cache"
 
-	// timeout in hours
-	timeoutHours = 1.0
+	// default time before a cached dependency is stale
+	defaultTTL = time.Hour
 )
 
-// flags
+// settings
 var (
-	clear bool
-	skip  bool
+	skip bool
+	ttl  = defaultTTL
 )
 
 var (
-	// the cache, a map of repos urls to timestamps
-	cache map[string]time.Time
+	// the cache, a map of keys (see key()) to entries
+	cache map[string]*Entry
+
+	// the keys changed by this process since the cache was read, merged into the file by Write
+	changed = make(map[string]bool)
+
+	// guards cache and changed, dependencies may be installed concurrently
+	lock sync.Mutex
 
 	// the full path to the cache json
 	cacheFile string
 )
 
-func init() {
-	flag.BoolVar(&clear, "clear-cache", false, "Delete the time based cache")
-	flag.BoolVar(&skip, "skip-cache", false, "Skip the time based cache for this run only")
+// Entry is the cached state of a single dependency
+type Entry struct {
+	Repo     string    `json:"repo"`
+	Path     string    `json:"path,omitempty"`
+	Time     time.Time `json:"time"`
+	Revision string    `json:"revision,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// the key of this entry in the cache
+	Key string `json:"-"`
 }
 
-// Clear clears the cache, returns true if the cache was cleared
-func Clear() (cleared bool) {
-	cleared = clear
+// Age returns the time since the entry was last fetched
+func (e Entry) Age() time.Duration {
+	return time.Since(e.Time)
+}
 
-	if clear {
-		parts := strings.Split(os.Getenv("GOPATH"), ":")
-		cacheFile = filepath.Join(parts[0], cacheFileName)
+// SetSkip sets whether to skip the cache, every dependency is then fetched and the cache file is not written
+func SetSkip(s bool) {
+	skip = s
+}
 
-		util.Print(colors.Yellow("Clearing cache file: " + cacheFile))
+// SetTTL sets the time before a cached dependency is fetched again
+func SetTTL(t time.Duration) {
+	ttl = t
+}
 
-		_, err := os.Stat(cacheFile)
-		if err != nil {
-			return
-		}
+// key returns the cache key of a dependency
+// The same repo can be installed in several places (parts of GOPATH or aliases), each of which is cached separately
+func key(d *dep.Dependency) string {
+	return d.Repo + " " + d.Path()
+}
 
-		err = os.Remove(cacheFile)
-		if err != nil {
-			util.Fatal(err)
-		}
+// Clear deletes the cache file, reporting it to r
+func Clear(r report.Reporter) (err error) {
+	parts := strings.Split(os.Getenv("GOPATH"), ":")
+	cacheFile = filepath.Join(parts[0], cacheFileName)
+
+	r.Warning(report.NoIndent, "Clearing cache file: "+cacheFile)
+
+	l, err := acquire(r)
+	if err != nil {
+		return
 	}
+	defer l.Release()
 
+	err = os.Remove(cacheFile)
+	if os.IsNotExist(err) {
+		err = nil
+	}
 	return
 }
 
-// Read reads the cache from disk
-func Read() {
+// Read reads the cache from disk, problems with the cache file are reported to r
+func Read(r report.Reporter) (err error) {
 	parts := strings.Split(os.Getenv("GOPATH"), ":")
 	cacheFile = filepath.Join(parts[0], cacheFileName)
 
-	cache = make(map[string]time.Time)
+	lock.Lock()
+	defer lock.Unlock()
+
+	changed = make(map[string]bool)
+	cache = make(map[string]*Entry)
 
 	if !util.Exists(cacheFile) {
 		return
 	}
 
-	util.Verbose("Reading cache file from " + cacheFile)
-
-	data, err := ioutil.ReadFile(cacheFile)
-	if err != nil {
-		util.Fatal(err)
-	}
+	r.Detail(report.NoIndent, "Reading cache file from "+cacheFile)
 
-	err = json.Unmarshal(data, &cache)
+	l, err := acquire(r)
 	if err != nil {
 		return
 	}
+	defer l.Release()
+
+	cache, err = load(r)
+	return
 }
 
 // Write writes the cache out to disk
-func Write() {
+// The file is read again first and only the entries changed by this process are merged in, so concurrent runs of
+// depman don't lose each other's entries. Entries for dependencies which no longer exist on disk are dropped.
+// Problems with the cache file are reported to r
+func Write(r report.Reporter) (err error) {
 	if skip {
 		return
 	}
 
+	lock.Lock()
+	defer lock.Unlock()
+
+	l, err := acquire(r)
+	if err != nil {
+		return
+	}
+	defer l.Release()
+
+	merged, err := load(r)
+	if err != nil {
+		return
+	}
+
+	for k := range changed {
+		if e, ok := cache[k]; ok {
+			merged[k] = e
+		} else {
+			delete(merged, k)
+		}
+	}
+
+	for k, e := range merged {
+		if e.Path != "" && !util.Exists(e.Path) {
+			r.Detail(report.NoIndent, "Removing cache entry for missing dependency "+e.Path)
+			delete(merged, k)
+		}
+	}
+
+	cache = merged
+	changed = make(map[string]bool)
+
 	var buf bytes.Buffer
 	str, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	json.Indent(&buf, str, "", "    ")
+	data := []byte(buf.String() + "\n")
+
+	r.Detail(report.NoIndent, "Writing cache file to "+cacheFile)
+
+	return util.WriteAtomic(cacheFile, data)
+}
+
+// Check returns the path of the cache file, and an error if it can't be parsed or written.
+// Unlike Read it doesn't lock the file or move a corrupt file aside
+func Check() (file string, err error) {
+	parts := strings.Split(os.Getenv("GOPATH"), ":")
+	file = filepath.Join(parts[0], cacheFileName)
+
+	data, err := ioutil.ReadFile(file)
 	if err == nil {
-		json.Indent(&buf, str, "", "    ")
-		data := []byte(buf.String() + "\n")
+		var raw map[string]json.RawMessage
+		if err = json.Unmarshal(data, &raw); err != nil {
+			return file, errors.New("is corrupt: " + err.Error())
+		}
 
-		util.Verbose("Writing cache file to " + cacheFile)
+		var f *os.File
+		if f, err = os.OpenFile(file, os.O_WRONLY, 0); err != nil {
+			return
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return
+	}
 
-		err = ioutil.WriteFile(cacheFile, data, 0644)
-		if err != nil {
-			util.Fatal(err)
+	// the file is replaced by a temporary file written next to it
+	f, err := ioutil.TempFile(filepath.Dir(file), cacheFileName+".tmp")
+	if err != nil {
+		return
+	}
+	f.Close()
+	err = os.Remove(f.Name())
+	return
+}
+
+// acquire locks the cache file against other depman processes, reporting to r while it waits
+func acquire(r report.Reporter) (*flock.Lock, error) {
+	return flock.Acquire(cacheFile+".lock", 0, func(waited time.Duration) {
+		if waited == 0 {
+			r.Detail(report.NoIndent, "Waiting for another depman process to release "+cacheFile)
+		} else {
+			r.Warning(report.NoIndent, "Still waiting after "+waited.String()+" for another depman process to release "+cacheFile)
 		}
+	})
+}
+
+// load reads the entries in the cache file, the caller must hold the file lock.
+// A corrupt cache file is reported to r and moved aside, so it is replaced on the next Write
+func load(r report.Reporter) (entries map[string]*Entry, err error) {
+	entries = make(map[string]*Entry)
+
+	data, err := ioutil.ReadFile(cacheFile)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return
+	}
+
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		r.Warning(report.NoIndent, "Warning: cache file "+cacheFile+" is corrupt, starting with an empty cache ("+err.Error()+")")
+		err = os.Rename(cacheFile, cacheFile+".corrupt")
+		if err == nil {
+			r.Warning(report.NoIndent, "Warning: the corrupt cache file was moved to "+cacheFile+".corrupt")
+		}
+		return entries, nil
+	}
+
+	for k, v := range raw {
+		e := new(Entry)
+
+		// older versions of depman stored a timestamp per repo
+		var ts time.Time
+		if json.Unmarshal(v, &ts) == nil {
+			e.Repo = k
+			e.Time = ts
+		} else if json.Unmarshal(v, e) != nil {
+			r.Detail(report.NoIndent, "Ignoring invalid cache entry "+k)
+			continue
+		}
+
+		e.Key = k
+		entries[k] = e
 	}
+
+	migrate(entries)
 	return
 }
 
-// IsStale returns true if the cached dependency is older than timeoutHours
+// migrate rekeys the entries of older versions of depman, which were keyed by repo alone, by repo and install path.
+// The install path is the one a dependency without an alias would have, entries for repos which are not installed
+// there are dropped
+func migrate(entries map[string]*Entry) {
+	for k, e := range entries {
+		// repos may contain spaces, e.g. the upgrade check, so the key is compared with the repo
+		if k != e.Repo {
+			continue
+		}
+		delete(entries, k)
+
+		d := &dep.Dependency{Repo: k}
+		if !util.Exists(d.Path()) {
+			continue
+		}
+
+		e.Path = d.Path()
+		e.Key = key(d)
+		if _, ok := entries[e.Key]; !ok {
+			entries[e.Key] = e
+		}
+	}
+}
+
+// IsStale returns true if the cached dependency is older than its cache ttl
+// The cache is not updated until Touch is called, so a dependency that fails to install stays stale
 func IsStale(d *dep.Dependency) (stale bool) {
 
 	if skip || d.SkipCache {
 		return true
 	}
 
-	ts, ok := cache[d.Repo]
+	lock.Lock()
+	defer lock.Unlock()
+
+	e, ok := cache[key(d)]
 
 	// item is in the cache
 	if ok {
 		// item is old
-		if time.Since(ts).Hours() > timeoutHours {
+		if e.Age() > TTL(d) {
 			stale = true
-			cache[d.Repo] = time.Now()
 		}
 	} else {
 		stale = true
-		cache[d.Repo] = time.Now()
 	}
 
 	return
 }
+
+// TTL returns the time before d is stale, the "cache-ttl" field of d if set, otherwise --cache-ttl
+func TTL(d *dep.Dependency) time.Duration {
+	if t, ok := d.TTL(); ok {
+		return t
+	}
+	return ttl
+}
+
+// DefaultTTL returns the time before a dependency without a "cache-ttl" field is stale (--cache-ttl)
+func DefaultTTL() time.Duration {
+	return ttl
+}
+
+// File returns the full path to the cache file, it is only set after Read or Clear
+func File() string {
+	return cacheFile
+}
+
+// Touch marks the dependency as fresh, it should be called once the dependency has been updated to revision
+func Touch(d *dep.Dependency, revision string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	e := entry(d)
+	e.Time = time.Now()
+	changed[e.Key] = true
+	e.Revision = revision
+	e.Error = ""
+}
+
+// Failed records the error that occurred while updating the dependency, it stays stale
+func Failed(d *dep.Dependency, msg string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	e := entry(d)
+	e.Error = msg
+	changed[e.Key] = true
+}
+
+// entry returns the entry for d, creating it if necessary, the caller must hold lock
+func entry(d *dep.Dependency) (e *Entry) {
+	if cache == nil {
+		cache = make(map[string]*Entry)
+	}
+
+	k := key(d)
+	e, ok := cache[k]
+	if !ok {
+		e = &Entry{Repo: d.Repo, Key: k}
+
+		// entries without a path (such as the upgrade check) are never garbage collected
+		if util.Exists(d.Path()) {
+			e.Path = d.Path()
+		}
+		cache[k] = e
+	}
+	return
+}
+
+// Key returns the cache key of a dependency
+func Key(d *dep.Dependency) string {
+	return key(d)
+}
+
+// Entries returns a copy of all the entries in the cache, sorted by key
+func Entries() (entries []Entry) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, e := range cache {
+		entries = append(entries, *e)
+	}
+
+	sort.Sort(byKey(entries))
+	return
+}
+
+// Evict removes the entry with key from the cache, returns false if there is no such entry
+func Evict(key string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	_, ok := cache[key]
+	delete(cache, key)
+	changed[key] = true
+	return ok
+}
+
+// Refresh marks the entry with key as fresh, returns false if there is no such entry
+func Refresh(key string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	e, ok := cache[key]
+	if ok {
+		e.Time = time.Now()
+		changed[key] = true
+	}
+	return ok
+}
+
+// byKey sorts entries by key
+type byKey []Entry
+
+func (b byKey) Len() int           { return len(b) }
+func (b byKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byKey) Less(i, j int) bool { return b[i].Key < b[j].Key }
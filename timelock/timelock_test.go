@@ -3,10 +3,19 @@ package timelock
 // Copyright 2013-2014 Vubeology, Inc.
 
 import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/vube/depman/colors"
 	"github.com/vube/depman/dep"
+	"github.com/vube/depman/fixture"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/util"
 	. "launchpad.net/gocheck"
 )
 
@@ -20,7 +29,7 @@ type TimelockSuite struct{}
 var _ = Suite(&TimelockSuite{})
 
 func (s *TimelockSuite) TestIsStale(c *C) {
-	cache = make(map[string]time.Time)
+	cache = make(map[string]*Entry)
 
 	old := new(dep.Dependency)
 	old.Repo = "old"
@@ -28,10 +37,150 @@ func (s *TimelockSuite) TestIsStale(c *C) {
 	new := new(dep.Dependency)
 	new.Repo = "new"
 
-	cache["old"] = time.Now().Add(-2 * time.Hour)
-	cache["new"] = time.Now().Add(-1 * time.Minute)
+	cache[key(old)] = &Entry{Repo: "old", Time: time.Now().Add(-2 * time.Hour)}
+	cache[key(new)] = &Entry{Repo: "new", Time: time.Now().Add(-1 * time.Minute)}
 
 	c.Check(IsStale(old), Equals, true)
 	c.Check(IsStale(new), Equals, false)
 
+	// still stale until touched
+	c.Check(IsStale(old), Equals, true)
+	Touch(old, "")
+	c.Check(IsStale(old), Equals, false)
+
+}
+
+func (s *TimelockSuite) TestTTL(c *C) {
+	cache = make(map[string]*Entry)
+	ttl = time.Hour
+	defer func() { ttl = defaultTTL }()
+
+	d := &dep.Dependency{Repo: "repo"}
+	cache[key(d)] = &Entry{Repo: "repo", Time: time.Now().Add(-30 * time.Minute)}
+	c.Check(IsStale(d), Equals, false)
+
+	// per dependency ttl overrides the global ttl
+	d.CacheTTL = "10m"
+	c.Check(IsStale(d), Equals, true)
+
+	d.CacheTTL = ""
+	ttl = 10 * time.Minute
+	c.Check(IsStale(d), Equals, true)
+}
+
+func (s *TimelockSuite) TestKeyIncludesPath(c *C) {
+	cache = make(map[string]*Entry)
+
+	a := &dep.Dependency{Repo: "https://example.com/repo.git", Type: dep.TypeGitClone, Alias: "a"}
+	b := &dep.Dependency{Repo: "https://example.com/repo.git", Type: dep.TypeGitClone, Alias: "b"}
+
+	Touch(a, "")
+	c.Check(IsStale(a), Equals, false)
+	c.Check(IsStale(b), Equals, true)
+}
+
+// quiet discards what is reported about the cache file
+var quiet = report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard)
+
+// useTempGoPath points GOPATH at a new temporary directory and reads the (empty) cache from it
+func useTempGoPath(c *C) (dir string, restore func()) {
+	colors.Mock()
+	util.Mock(new(bytes.Buffer))
+
+	dir, restore = fixture.GoPath(c)
+	Read(quiet)
+	return
+}
+
+func (s *TimelockSuite) TestMigrate(c *C) {
+	dir, restore := useTempGoPath(c)
+	defer restore()
+
+	installed := &dep.Dependency{Repo: "example.com/installed"}
+	c.Assert(os.MkdirAll(installed.Path(), 0755), IsNil)
+
+	legacy := `{"example.com/installed": "2014-01-02T03:04:05Z", "example.com/gone": "2014-01-02T03:04:05Z",
+		"internal check": {"repo": "internal check", "time": "2014-01-02T03:04:05Z"}}`
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, cacheFileName), []byte(legacy), 0644), IsNil)
+	Read(quiet)
+
+	entries := Entries()
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Key, Equals, key(installed))
+	c.Check(entries[0].Repo, Equals, "example.com/installed")
+	c.Check(entries[0].Path, Equals, installed.Path())
+	c.Check(entries[0].Time.Year(), Equals, 2014)
+}
+
+func (s *TimelockSuite) TestWriteMerges(c *C) {
+	_, restore := useTempGoPath(c)
+	defer restore()
+
+	a := &dep.Dependency{Repo: "a"}
+	b := &dep.Dependency{Repo: "b"}
+	gone := &dep.Dependency{Repo: "gone"}
+
+	Touch(a, "1")
+	Touch(gone, "1")
+	Write(quiet)
+	Read(quiet)
+
+	// meanwhile, another process adds b and evicts gone
+	saved, savedChanged := cache, changed
+	changed = make(map[string]bool)
+	Touch(b, "2")
+	Evict(key(gone))
+	Write(quiet)
+	cache, changed = saved, savedChanged
+
+	Touch(a, "3")
+	Write(quiet)
+	Read(quiet)
+
+	entries := Entries()
+	c.Assert(entries, HasLen, 2)
+	c.Check(entries[0].Repo, Equals, "a")
+	c.Check(entries[0].Revision, Equals, "3")
+	c.Check(entries[1].Repo, Equals, "b")
+	c.Check(entries[1].Revision, Equals, "2")
+}
+
+func (s *TimelockSuite) TestCorruptCache(c *C) {
+	dir, restore := useTempGoPath(c)
+	defer restore()
+
+	file := filepath.Join(dir, cacheFileName)
+	c.Assert(ioutil.WriteFile(file, []byte(`{"a": {"repo": "a"`), 0644), IsNil)
+
+	var buf bytes.Buffer
+	Read(report.NewText(log.New(&buf, "", 0), &buf))
+	c.Check(Entries(), HasLen, 0)
+	c.Check(util.Exists(file+".corrupt"), Equals, true)
+	c.Check(buf.String(), Matches, "(?s)Warning: cache file .* is corrupt.*moved to .*\\.corrupt\n")
+
+	Touch(&dep.Dependency{Repo: "a"}, "")
+	Write(quiet)
+	Read(quiet)
+	c.Check(Entries(), HasLen, 1)
+}
+
+func (s *TimelockSuite) TestCheck(c *C) {
+	dir, restore := useTempGoPath(c)
+	defer restore()
+
+	// no cache file yet
+	file, err := Check()
+	c.Check(file, Equals, filepath.Join(dir, cacheFileName))
+	c.Check(err, IsNil)
+
+	c.Assert(ioutil.WriteFile(file, []byte(`{"a": {"repo": "a"`), 0644), IsNil)
+	_, err = Check()
+	c.Check(err, ErrorMatches, "is corrupt: .*")
+
+	// unlike Read the file is left alone
+	c.Check(util.Exists(file), Equals, true)
+
+	c.Assert(ioutil.WriteFile(file, []byte(`{}`), 0644), IsNil)
+	_, err = Check()
+	c.Check(err, IsNil)
 }
@@ -7,18 +7,26 @@ import (
 	"github.com/vube/depman/colors"
 	"github.com/vube/depman/dep"
 	"github.com/vube/depman/install"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
 	"github.com/vube/depman/util"
 )
 
-// Update rewrites Dependency name in deps.json to use the last commit in branch as version
-func Update(deps dep.DependencyMap, name string, branch string) {
-	util.Print(colors.Blue("Updating:"))
+// Update rewrites Dependency name in deps.json to use the last commit in branch as version, reporting progress to r
+func Update(deps dep.DependencyMap, name string, branch string, r report.Reporter) {
+	r.Info(report.NoIndent, colors.Blue("Updating:"))
+
+	if util.Offline() {
+		util.Abort(result.Config, "Update requires network access, it cannot be used with --offline")
+	}
 
 	d, ok := deps.Map[name]
 	if !ok {
-		util.Fatal(colors.Red("Dependency Name '" + name + "' not found in deps.json"))
+		util.Abort(result.Config, "Dependency Name '"+name+"' not found in deps.json")
 	}
 
+	d.Log = util.NewLogger(r, false, 0)
+
 	// record the old version
 	oldVersion := d.Version
 
@@ -33,18 +41,18 @@ func Update(deps dep.DependencyMap, name string, branch string) {
 	// get the last commit on the newly checked out branch
 	v, err := d.VCS.LastCommit(d, branch)
 	if err != nil {
-		util.Fatal(err)
+		util.Abort(result.VCS, err.Error())
 	}
 
 	// set the version to be the last commit
 	d.Version = v
 
-	util.PrintIndent(colors.Blue(name) + " (" + oldVersion + " --> " + d.Version + ")")
+	r.Info(0, colors.Blue(name)+" ("+oldVersion+" --> "+d.Version+")")
 
 	util.Cd(pwd)
 	deps.Map[name] = d
 	deps.Write()
 
-	install.Install(deps)
+	install.Install(deps, r)
 
 }
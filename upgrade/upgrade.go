@@ -7,6 +7,7 @@ package upgrade
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -28,6 +29,9 @@ const (
 	none    = ""
 )
 
+// ErrOffline is registered when Self is called with --offline
+var ErrOffline = errors.New("requires network access")
+
 var (
 	checkCalled bool
 	selfCalled  bool
@@ -44,13 +48,20 @@ func init() {
 // Self upgrades this version of depman to the latest on the master branch
 func Self(version string) {
 	selfCalled = true
+
+	if util.Offline() {
+		result.Register(&result.Error{Kind: result.Offline, Op: "self-upgrade", Err: ErrOffline})
+		util.Print(colors.Red("Self-Upgrade requires network access, it cannot be used with --offline"))
+		return
+	}
+
 	util.Print(colors.Blue("Upgrading depman..."))
-	util.RunCommand("go get -u github.com/vube/depman")
+	util.RunCommand("go", "get", "-u", "github.com/vube/depman")
 
 	cmd := exec.Command("depman", "--version")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		result.RegisterError()
+		result.Register(&result.Error{Op: "self-upgrade", Command: "depman --version", Output: string(out), Err: err})
 		util.Print(colors.Red(string(out)))
 		return
 	}
@@ -67,17 +78,18 @@ func Self(version string) {
 //============================================
 
 // Check checks for a newer version of depman on github, the result is written on a channel
-// It is expected that this will be called in a goroutine
+// It is expected that this will be called in a goroutine, or before Print
 func Check(ver string) {
 	var str string
 	checkCalled = true
-	channel = make(chan string, 0)
+	channel = make(chan string, 1)
 
 	self = new(dep.Dependency)
 	self.Repo = "depman internal upgrade check"
 
 	if timelock.IsStale(self) {
 		str, checkError = check(ver)
+		timelock.Touch(self, "")
 	} else {
 		str = none
 	}
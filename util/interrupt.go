@@ -0,0 +1,137 @@
+package util
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/vube/depman/colors"
+	"github.com/vube/depman/result"
+)
+
+// ErrInterrupted is returned by commands which were cancelled because depman was interrupted
+var ErrInterrupted = errors.New("interrupted")
+
+var (
+	// cancelled when depman is interrupted, all commands are run with a context derived from this one
+	baseContext, cancel = context.WithCancel(context.Background())
+
+	// guards baseContext and cancel
+	contextLock sync.Mutex
+
+	// maximum run time of a single command, zero means no limit
+	commandTimeout time.Duration
+)
+
+// CatchInterrupt cancels all running and future commands when SIGINT is received,
+// so that the caller can clean up and save its state before exiting
+func CatchInterrupt() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+
+	go func() {
+		<-c
+		Print(colors.Yellow("Interrupted, cleaning up..."))
+		Interrupt()
+
+		// a second interrupt exits immediately
+		<-c
+		OsExit(130)
+	}()
+}
+
+// SetContext derives the context of all future commands from ctx, so cancelling ctx has the same effect as Interrupt
+func SetContext(ctx context.Context) {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+	baseContext, cancel = context.WithCancel(ctx)
+}
+
+// Context returns the context all commands are run with
+func Context() context.Context {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+	return baseContext
+}
+
+// SetCommandTimeout sets the maximum time a single command may run, zero means no limit
+func SetCommandTimeout(t time.Duration) {
+	commandTimeout = t
+}
+
+// Interrupt cancels all running and future commands
+func Interrupt() {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+	cancel()
+}
+
+// Interrupted returns true if depman has been interrupted
+func Interrupted() bool {
+	return Context().Err() != nil
+}
+
+// commandContext returns a context for running a single command, it is cancelled after --command-timeout or on interrupt
+func commandContext() (ctx context.Context, done context.CancelFunc) {
+	if commandTimeout > 0 {
+		return context.WithTimeout(Context(), commandTimeout)
+	}
+	return context.WithCancel(Context())
+}
+
+// nonInteractiveEnv returns the environment for running commands,
+// set up so that VCS tools fail rather than waiting on a password or host key prompt
+func nonInteractiveEnv() (env []string) {
+	env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GCM_INTERACTIVE=never",
+		"HGPLAIN=1",
+	)
+
+	// paramiko, the default ssh client of bzr, prompts for passwords itself
+	if os.Getenv("BZR_SSH") == "" {
+		env = append(env, "BZR_SSH=openssh")
+	}
+
+	// ssh's prompts are answered by a program which always fails. Passing -o BatchMode=yes instead would need
+	// GIT_SSH_COMMAND, which overrides the user's core.sshCommand, and hg and bzr have no equivalent
+	if os.Getenv("SSH_ASKPASS") == "" {
+		if askpass, err := exec.LookPath("false"); err == nil {
+			env = append(env, "SSH_ASKPASS="+askpass, "SSH_ASKPASS_REQUIRE=force")
+		}
+	}
+	return
+}
+
+// nonInteractiveArgs returns args with the options which keep the VCS tool from prompting
+func nonInteractiveArgs(args []string) []string {
+	if len(args) > 0 && args[0] == "hg" {
+		return append([]string{"hg", "--config", "ui.interactive=false"}, args[1:]...)
+	}
+	return args
+}
+
+// TimeoutError is the error of a command which ran for longer than --command-timeout
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+// TimedOut returns true if err, or the error of the *result.Error err, is a TimeoutError
+func TimedOut(err error) bool {
+	if e, ok := err.(*result.Error); ok {
+		err = e.Err
+	}
+	_, ok := err.(*TimeoutError)
+	return ok
+}
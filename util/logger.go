@@ -0,0 +1,247 @@
+package util
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
+)
+
+// Logger runs commands for a single dependency and reports their output to a report.Reporter.
+// An unbuffered Logger reports directly, a buffered Logger holds its events until Flush is called,
+// this keeps the output of dependencies installed in parallel from interleaving
+type Logger struct {
+	r report.Reporter
+
+	// the Buffer wrapping the Reporter, if the Logger is buffered
+	buf *report.Buffer
+
+	// how deeply the dependency is nested in the tree
+	depth int
+
+	// the dependency whose output this is, and when it was started (see PrintDep)
+	subject report.Dependency
+	start   time.Time
+}
+
+// NewLogger returns a Logger reporting to r at depth, if buffered is true events are held until Flush is called
+func NewLogger(r report.Reporter, buffered bool, depth int) (l *Logger) {
+	l = &Logger{r: r, depth: depth}
+	if buffered {
+		l.buf = report.NewBuffer(r)
+		l.r = l.buf
+	}
+	return
+}
+
+// DefaultLogger returns an unbuffered Logger reporting to NewReporter at the current indentation level,
+// for commands run outside of an install
+func DefaultLogger() *Logger {
+	return NewLogger(NewReporter(), false, indentLevel)
+}
+
+// dependency returns the dependency of the Logger, with the time since it was started
+func (l *Logger) dependency() (d report.Dependency) {
+	d = l.subject
+	d.Depth = l.depth
+	if !l.start.IsZero() {
+		d.Duration = time.Since(l.start)
+	}
+	return
+}
+
+// Print reports s as a message which is not indented
+func (l *Logger) Print(s string) {
+	l.r.Info(report.NoIndent, s)
+}
+
+// PrintIndent reports s as a message
+func (l *Logger) PrintIndent(s string) {
+	l.r.Info(l.depth, s)
+}
+
+// VerboseIndent reports s as a message which is only displayed with --verbose
+func (l *Logger) VerboseIndent(s string) {
+	l.r.Detail(l.depth, s)
+}
+
+// Warn reports s as a warning
+func (l *Logger) Warn(s string) {
+	l.r.Warning(l.depth, s)
+}
+
+// Notice reports s as a warning immediately, even if the Logger is buffered
+func (l *Logger) Notice(s string) {
+	if l.buf != nil {
+		l.buf.Target().Warning(l.depth, s)
+		return
+	}
+	l.r.Warning(l.depth, s)
+}
+
+// Subject sets the dependency which the following events of this Logger are about, without reporting its start
+func (l *Logger) Subject(name string, version string, repo string) {
+	l.subject = report.Dependency{Name: name, Repo: repo, Version: version}
+}
+
+// PrintDep reports the start of a dependency, which all the following events of this Logger are about
+func (l *Logger) PrintDep(name string, version string, repo string, stale bool) {
+	l.subject = report.Dependency{Name: name, Repo: repo, Version: version, Stale: stale}
+	l.start = time.Now()
+	l.r.DependencyStart(l.dependency())
+}
+
+// Fetched reports that the dependency was fetched
+func (l *Logger) Fetched() {
+	l.r.Fetched(l.dependency())
+}
+
+// CheckedOut reports that the dependency was checked out at head
+func (l *Logger) CheckedOut(head string) {
+	d := l.dependency()
+	d.Head = head
+	l.r.CheckedOut(d)
+}
+
+// Error reports that the dependency failed with e
+func (l *Logger) Error(e *result.Error) {
+	l.r.Error(l.dependency(), e)
+}
+
+// Flush passes any buffered events on to the Reporter, it is a no-op for unbuffered Loggers
+func (l *Logger) Flush() {
+	if l.buf != nil {
+		l.buf.Flush()
+	}
+}
+
+// Run executes the command args in dir (or the current working directory if dir is empty), catching errors and printing useful messages.
+// The arguments are passed to the command as is, they are only quoted for display.
+// Commands are run without a terminal, and are killed after --command-timeout or when depman is interrupted.
+// A failure is registered with the result package, and returned as a *result.Error (or ErrInterrupted)
+func (l *Logger) Run(dir string, args ...string) (err error) {
+	out, err := l.exec(dir, args, false)
+	if err != nil {
+		err = l.Fail(args, out, err)
+	}
+	return
+}
+
+// Try is Run without reporting a failure, it returns the output of the command so the caller can pass it to Fail
+// if its fallback fails too. It is meant for commands which have a fallback
+func (l *Logger) Try(dir string, args ...string) (out []byte, err error) {
+	return l.exec(dir, args, false)
+}
+
+// Succeeds runs the command args in dir and returns true if it exits successfully, failures are not reported.
+// It is meant for commands which query the state of a repository, so the command is only displayed with --debug
+func (l *Logger) Succeeds(dir string, args ...string) bool {
+	_, err := l.exec(dir, args, true)
+	return err == nil
+}
+
+// Capture runs the command args in dir and returns its trimmed output, failures are not reported.
+// It is meant for commands which query the state of a repository, so the command is only displayed with --debug
+func (l *Logger) Capture(dir string, args ...string) (out string, err error) {
+	b, err := l.exec(dir, args, true)
+	out = strings.TrimSpace(string(b))
+	return
+}
+
+// exec runs the command args in dir and returns its output, errors are not reported.
+// query is true for commands which only query the state of a repository
+func (l *Logger) exec(dir string, args []string, query bool) (out []byte, err error) {
+	cmd := report.Command{Dependency: l.dependency(), Args: Quote(args), Query: query}
+	l.r.CommandStart(cmd)
+
+	ctx, done := commandContext()
+	defer done()
+
+	run := nonInteractiveArgs(args)
+	c := exec.CommandContext(ctx, run[0], run[1:]...)
+	c.Dir = dir
+	c.Env = nonInteractiveEnv()
+
+	// don't wait forever on children (e.g. ssh) which hold the output open after the command is killed
+	c.WaitDelay = 5 * time.Second
+
+	start := time.Now()
+	out, err = c.CombinedOutput()
+	duration := time.Since(start)
+
+	switch {
+	case Interrupted():
+		err = ErrInterrupted
+	case ctx.Err() == context.DeadlineExceeded:
+		err = &TimeoutError{Timeout: commandTimeout}
+		out = append(out, []byte(err.Error())...)
+	}
+
+	cmd.Output = string(out)
+	cmd.Err = err
+	cmd.Duration = duration
+	l.r.CommandRun(cmd)
+	return
+}
+
+// Fail registers the error err of the command args and prints its output.
+// It returns the registered *result.Error, or ErrInterrupted so callers can still recognise an interrupt
+func (l *Logger) Fail(args []string, out []byte, err error) error {
+	o := strings.TrimRight(string(out), "\n")
+
+	e := &result.Error{Kind: result.VCS, Command: Quote(args), Output: o, Err: err}
+	if isDirty(out) {
+		e.Kind = result.Dirty
+	}
+	result.Register(e)
+
+	l.r.CommandFailed(report.Command{Dependency: l.dependency(), Args: e.Command, Output: o, Err: err})
+
+	if err == ErrInterrupted {
+		return err
+	}
+	return e
+}
+
+// output of VCS commands which refuse to run because of local changes
+var dirtyErrors = []string{
+	"would be overwritten by",
+	"please commit your changes or stash them",
+	"uncommitted changes",
+	"untracked working tree files would be",
+	"abort: outstanding uncommitted changes",
+	"working tree has uncommitted changes",
+}
+
+// isDirty returns true if the output of a failed command shows it was refused because of local changes
+func isDirty(out []byte) bool {
+	o := strings.ToLower(string(out))
+	for _, e := range dirtyErrors {
+		if strings.Contains(o, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Quote formats args as a shell command line, quoting any argument that the shell would split or interpret.
+// The result is only meant for display
+func Quote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.Trim(a, safeChars) == "" {
+			quoted[i] = a
+		} else {
+			quoted[i] = "'" + strings.Replace(a, "'", `'\''`, -1) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// characters which never need quoting
+const safeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=@%:,./"
@@ -0,0 +1,95 @@
+package util
+
+// Copyright 2013-2014 Vubeology, Inc.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vube/depman/colors"
+)
+
+var (
+	// number of times to retry a network command which failed with a transient error
+	retries = 2
+
+	// time to wait before the first retry, doubled for each following retry
+	retryWait = 2 * time.Second
+)
+
+// SetRetries sets the number of times a network command is retried, and the time to wait before the first retry
+func SetRetries(n int, wait time.Duration) {
+	retries = n
+	retryWait = wait
+}
+
+// transientErrors are fragments of VCS output which indicate a failure worth retrying
+var transientErrors = []string{
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"connection timed out",
+	"connection reset",
+	"connection refused",
+	"operation timed out",
+	"network is unreachable",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"unexpected disconnect",
+	"gnutls_handshake() failed",
+	"ssl_error_syscall",
+	"tls handshake timeout",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// RunNetwork is Run for commands which talk to a remote server.
+// Failures which look like network errors are retried up to --retries times, waiting --retry-wait before the first retry
+// and doubling the wait each time. If reset is not nil it is called before each retry to undo the work of the failed attempt.
+// Commands killed after --command-timeout are not retried. The error is only reported once the last attempt has failed
+func (l *Logger) RunNetwork(dir string, reset func(), args ...string) (err error) {
+	out, err := l.TryNetwork(dir, reset, args...)
+	if err != nil {
+		err = l.Fail(args, out, err)
+	}
+	return
+}
+
+// TryNetwork is RunNetwork without reporting the final error, it returns the output of the last attempt.
+// It is meant for commands which have a fallback
+func (l *Logger) TryNetwork(dir string, reset func(), args ...string) (out []byte, err error) {
+	wait := retryWait
+	interrupted := Context().Done()
+
+	for attempt := 1; ; attempt++ {
+		out, err = l.exec(dir, args, false)
+		if err == nil || err == ErrInterrupted || TimedOut(err) || attempt > retries || !isTransient(out) {
+			return
+		}
+
+		l.VerboseIndent(colors.Yellow(fmt.Sprintf("# network error, retrying in %s (retry %d of %d)", wait, attempt, retries)))
+
+		select {
+		case <-time.After(wait):
+		case <-interrupted:
+		}
+		wait *= 2
+
+		if reset != nil {
+			reset()
+		}
+	}
+}
+
+// isTransient returns true if the output of a failed command matches a known network error
+func isTransient(out []byte) bool {
+	o := strings.ToLower(string(out))
+	for _, e := range transientErrors {
+		if strings.Contains(o, e) {
+			return true
+		}
+	}
+	return false
+}
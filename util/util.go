@@ -1,18 +1,24 @@
 // Package util provides various utility functions.
-// It also defines the following flags: --verbose,  --debug, --silent, and --version
+// It also defines the display flags --verbose, --debug, --silent, --version, --output and --no-progress, which are
+// registered by Flags
 package util
 
 // Copyright 2013-2014 Vubeology, Inc.
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/vube/depman/colors"
+	"github.com/vube/depman/report"
 	"github.com/vube/depman/result"
 )
 
@@ -28,6 +34,9 @@ var (
 	// Whether to display verbose messages
 	verbose bool
 
+	// The format of the output on stdout, text or json
+	output = "text"
+
 	// don't display any output
 	silent bool
 
@@ -37,6 +46,12 @@ var (
 	// current indent level
 	indentLevel int
 
+	// never access the network
+	offline bool
+
+	// don't display the progress line on terminals
+	noProgress bool
+
 	// Sub command like install add or update
 	command string
 )
@@ -50,6 +65,7 @@ var (
 	OsExit     = os.Exit
 	Cd         = defaultCd
 	indent     = defaultIndent
+	indentAt   = report.DefaultIndent
 )
 
 var (
@@ -58,22 +74,62 @@ var (
 
 	logger *log.Logger
 
+	// the progress line shared by the Reporters of the run, since it owns the last line of the terminal
+	progress *report.Progress
+
 	// OutputTarget is an io.Writer to write messages to, change it to a bytes.buffer to test output
 	OutputTarget = os.Stderr
+
+	// ResultTarget is an io.Writer to write results (events with --output=json) to
+	ResultTarget io.Writer = os.Stdout
 )
 
 func init() {
-	flag.BoolVar(&debug, "debug", false, "Display debug messages. Implies --verbose")
-	flag.BoolVar(&verbose, "verbose", false, "Display commands as they are run, and other informative messages")
-	flag.BoolVar(&silent, "silent", false, "Don't display normal output. Overrides --debug and --verbose")
-	flag.BoolVar(&displayVersion, "version", false, "Display version number")
 	logger = log.New(OutputTarget, "", 0)
-	Fatal = logger.Fatal
+	Fatal = defaultFatal
+}
+
+// defaultFatal prints v and exits with the code for the errors registered so far (see result.ExitCode), at least 1
+func defaultFatal(v ...interface{}) {
+	if progress != nil {
+		progress.Stop()
+	}
+	logger.Output(2, fmt.Sprint(v...))
+
+	code := result.ExitCode()
+	if code == 0 {
+		code = 1
+	}
+	OsExit(code)
 }
 
-// Parse command line flags
-func Parse() {
+// Abort registers an error of kind with the message msg, then prints msg and exits (see Fatal)
+func Abort(kind result.Kind, msg string) {
+	e := &result.Error{Kind: kind, Err: errors.New(msg)}
+	result.Register(e)
+	if JSON() {
+		NewReporter().Error(report.Dependency{}, e)
+	}
+	Fatal(colors.Red(msg))
+}
+
+// Flags registers the display flags on fs
+func Flags(fs *flag.FlagSet) {
+	fs.BoolVar(&debug, "debug", false, "Display debug messages. Implies --verbose")
+	fs.BoolVar(&verbose, "verbose", false, "Display commands as they are run, and other informative messages")
+	fs.BoolVar(&silent, "silent", false, "Don't display normal output. Overrides --debug and --verbose")
+	fs.BoolVar(&displayVersion, "version", false, "Display version number")
+	fs.StringVar(&output, "output", "text", "Output format, 'text' or 'json' (newline delimited JSON events on stdout, text messages still go to stderr)")
+	fs.BoolVar(&noProgress, "no-progress", false, "Display each dependency instead of a progress line when stderr is a terminal")
+}
+
+// Parse parses the command line flags, then calls defaults (if it is not nil) to set those which were not given,
+// before they are interpreted
+func Parse(defaults func()) {
 	flag.Parse()
+	if defaults != nil {
+		defaults()
+	}
 
 	if silent {
 		debug = false
@@ -84,6 +140,61 @@ func Parse() {
 		verbose = true
 		logger.SetFlags(log.Lshortfile)
 	}
+
+	output = strings.ToLower(output)
+	if output != "text" && output != "json" {
+		Abort(result.Config, "Invalid --output '"+output+"', must be 'text' or 'json'")
+	}
+}
+
+// NewReporter returns the Reporter selected by --output and --silent, configured by --verbose and --debug.
+// Text output to a terminal is displayed by a progress line, unless --verbose or --no-progress is set
+func NewReporter() report.Reporter {
+	t := report.NewText(logger, ResultTarget)
+	if silent {
+		t = report.NewQuiet(logger, ResultTarget)
+	}
+	t.Verbose = verbose
+	t.Debug = debug
+	t.Indent = indentAt
+
+	if JSON() {
+		return report.NewJSON(ResultTarget, t)
+	}
+
+	if !noProgress && !verbose && !silent && IsTerminal(OutputTarget) {
+		if progress == nil {
+			progress = report.NewProgress(t, OutputTarget, terminalWidth())
+			logger.SetOutput(progress)
+		}
+		return progress
+	}
+	return t
+}
+
+// IsTerminal returns true if f is a terminal rather than a file or a pipe
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the number of columns of the terminal, from $COLUMNS
+func terminalWidth() int {
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || width <= 0 {
+		return 80
+	}
+	return width
+}
+
+// JSON returns true if results are written as JSON (--output=json)
+func JSON() bool {
+	return output == "json"
+}
+
+// Offline returns true if depman must not access the network (--offline)
+func Offline() bool {
+	return offline
 }
 
 // Version displays the version of depman and optionally exits (--version)
@@ -122,28 +233,8 @@ func Pwd() (pwd string) {
 }
 
 // Wrapper on os.exec to catch errors, and print useful messages
-func defaultRun(cmd string) (err error) {
-
-	if verbose {
-		logger.Output(2, indent()+"$ "+cmd)
-	}
-
-	parts := strings.Split(cmd, " ")
-	c := exec.Command(parts[0], parts[1:]...)
-
-	out, err := c.CombinedOutput()
-
-	if err != nil {
-		result.RegisterError()
-		logger.Output(2, indent()+colors.Red("$ "+cmd))
-		o := strings.TrimRight(string(out), "\n")
-		logger.Output(2, indent()+colors.Red(o))
-	}
-
-	if len(out) > 0 && debug {
-		logger.Output(2, indent()+string(out))
-	}
-	return
+func defaultRun(args ...string) (err error) {
+	return DefaultLogger().Run("", args...)
 }
 
 // UpwardFind searches for file starting in dir and moving up the path.
@@ -169,6 +260,34 @@ func Exists(path string) (res bool) {
 	return
 }
 
+// WriteAtomic writes data to a temporary file next to path and renames it over path,
+// so readers never see a partially written file
+func WriteAtomic(path string, data []byte) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return
+}
+
 // IncreaseIndent Increments the indentation level used during PrintIndent calls
 func IncreaseIndent() {
 	indentLevel++
@@ -187,7 +306,7 @@ func defaultIndent() (res string) {
 // CheckPath causes the application to exit if path does not exist
 func CheckPath(path string) {
 	if !Exists(path) {
-		Fatal(colors.Red("Could not find '" + path + "' are you in the right directory?"))
+		Abort(result.Config, "Could not find '"+path+"' are you in the right directory?")
 	}
 }
 
@@ -196,11 +315,16 @@ func Mock(w io.Writer) {
 	verbose = false
 	debug = false
 	silent = false
+	noProgress = true
+	progress = nil
 	logger = log.New(w, "", 0)
 	Fatal = logger.Print
 	indent = func() string {
 		return ""
 	}
+	indentAt = func(int) string {
+		return ""
+	}
 
 }
 
@@ -244,27 +368,43 @@ func SetVerbose(v bool) {
 	verbose = v
 }
 
+// SetOffline sets --offline for testing
+func SetOffline(o bool) {
+	offline = o
+}
+
+// SetOutput sets --output for testing
+func SetOutput(o string) {
+	output = o
+}
+
 // PrintDep displays a dependency based on the --silent and --verbose flags
 func PrintDep(name string, version string, repo string, stale bool) {
+	if !silent {
+		logger.Output(2, indent()+depLine(name, version, repo, stale))
+	}
+}
+
+// depLine formats a dependency for display, the repo is only included if --verbose is set
+func depLine(name string, version string, repo string, stale bool) (line string) {
 	var staleMarker string
 
 	if stale {
 		staleMarker = " *"
 	}
 
-	if !silent {
-		if verbose {
-			logger.Output(2, indent()+colors.Blue(name)+colors.Yellow(" ("+version+")")+" "+repo+staleMarker)
-		} else {
-			logger.Output(2, indent()+colors.Blue(name)+colors.Yellow(" ("+version+")")+staleMarker)
-		}
+	line = colors.Blue(name) + colors.Yellow(" ("+version+")")
+	if verbose {
+		line += " " + repo
 	}
+	line += staleMarker
+	return
 }
 
 // GoPathIsSet causes the program to exit(1) if $GOPATH is not set
 func GoPathIsSet() {
 	goPath := os.Getenv("GOPATH")
 	if strings.TrimSpace(goPath) == "" {
-		log.Fatal(colors.Red("You must set GOPATH"))
+		Abort(result.Config, "You must set GOPATH")
 	}
 }
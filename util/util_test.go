@@ -4,12 +4,17 @@ package util
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/vube/depman/colors"
+	"github.com/vube/depman/report"
+	"github.com/vube/depman/result"
 	. "launchpad.net/gocheck"
 )
 
@@ -138,7 +143,7 @@ func (s *TestSuite) TestDefaultRun(c *C) {
 	_, err := os.Open("../tests/touch")
 	c.Check(err, Not(IsNil))
 
-	RunCommand("touch ../tests/touch")
+	RunCommand("touch", "../tests/touch")
 
 	_, err = os.Open("../tests/touch")
 	c.Check(err, IsNil)
@@ -150,14 +155,14 @@ func (s *TestSuite) TestDefaultRun(c *C) {
 	Mock(buf)
 
 	verbose = true
-	err = defaultRun("echo NNN")
+	err = defaultRun("echo", "NNN")
 	c.Check(err, IsNil)
 	c.Check(buf.String(), Equals, "$ echo NNN\n")
 
 	buf.Truncate(0)
 	verbose = false
 	debug = true
-	err = defaultRun("echo NNN")
+	err = defaultRun("echo", "NNN")
 	c.Check(err, IsNil)
 	c.Check(buf.String(), Equals, "NNN\n")
 
@@ -169,6 +174,94 @@ func (s *TestSuite) TestDefaultRun(c *C) {
 	c.Check(err, ErrorMatches, `exec: "none": executable file not found in \$PATH`)
 }
 
+func (s *TestSuite) TestRunWithSpaces(c *C) {
+	dir, err := ioutil.TempDir("", "Depman Unit Test")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	Mock(buf)
+	verbose = true
+
+	err = RunCommand("touch", dir+"/a file")
+	c.Check(err, IsNil)
+	c.Check(Exists(dir+"/a file"), Equals, true)
+	c.Check(buf.String(), Equals, "$ touch '"+dir+"/a file'\n")
+}
+
+func (s *TestSuite) TestRunTimeout(c *C) {
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	Mock(buf)
+
+	commandTimeout = 100 * time.Millisecond
+	defer func() { commandTimeout = 0 }()
+
+	err := RunCommand("sleep", "5")
+	c.Check(err, ErrorMatches, "timed out after 100ms")
+	c.Check(buf.String(), Equals, "$ sleep 5\ntimed out after 100ms\n")
+}
+
+func (s *TestSuite) TestNonInteractive(c *C) {
+	c.Check(nonInteractiveArgs([]string{"hg", "pull"}), DeepEquals, []string{"hg", "--config", "ui.interactive=false", "pull"})
+	c.Check(nonInteractiveArgs([]string{"git", "fetch"}), DeepEquals, []string{"git", "fetch"})
+
+	env := strings.Join(nonInteractiveEnv(), "\n")
+	for _, v := range []string{"GIT_TERMINAL_PROMPT=0", "HGPLAIN=1"} {
+		c.Check(strings.Contains(env, v), Equals, true, Commentf("%s", v))
+	}
+
+	// the user's core.sshCommand is kept
+	defer os.Setenv("GIT_SSH_COMMAND", os.Getenv("GIT_SSH_COMMAND"))
+	os.Unsetenv("GIT_SSH_COMMAND")
+	c.Check(strings.Contains(strings.Join(nonInteractiveEnv(), "\n"), "GIT_SSH_COMMAND"), Equals, false)
+}
+
+func (s *TestSuite) TestRunNetworkRetries(c *C) {
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	Mock(buf)
+	verbose = true
+
+	oldRetries, oldWait := retries, retryWait
+	defer func() { retries, retryWait = oldRetries, oldWait }()
+	retries = 2
+	retryWait = time.Millisecond
+
+	resets := 0
+	reset := func() { resets++ }
+
+	l := DefaultLogger()
+	err := l.RunNetwork("", reset, "sh", "-c", "echo 'fatal: unable to access: Could not resolve host: example.com'; exit 1")
+	c.Check(err, Not(IsNil))
+	c.Check(resets, Equals, 2)
+	c.Check(strings.Count(buf.String(), "# network error, retrying"), Equals, 2)
+
+	// other errors are not retried
+	resets = 0
+	buf.Truncate(0)
+	err = l.RunNetwork("", reset, "sh", "-c", "echo 'fatal: not a git repository'; exit 1")
+	c.Check(err, Not(IsNil))
+	c.Check(resets, Equals, 0)
+	c.Check(strings.Contains(buf.String(), "retrying"), Equals, false)
+
+	// nor are commands which timed out, even after printing a network error
+	SetCommandTimeout(100 * time.Millisecond)
+	defer SetCommandTimeout(0)
+	err = l.RunNetwork("", reset, "sh", "-c", "echo 'error: RPC failed'; exec sleep 5")
+	c.Check(TimedOut(err), Equals, true)
+	c.Check(resets, Equals, 0)
+	c.Check(strings.Contains(buf.String(), "retrying"), Equals, false)
+}
+
+func (s *TestSuite) TestQuote(c *C) {
+	c.Check(Quote([]string{"git", "checkout", "master"}), Equals, "git checkout master")
+	c.Check(Quote([]string{"git", "clone", "--", "/a b/c"}), Equals, "git clone -- '/a b/c'")
+	c.Check(Quote([]string{"echo", ""}), Equals, "echo ''")
+	c.Check(Quote([]string{"echo", "it's"}), Equals, `echo 'it'\''s'`)
+}
+
 func (s *TestSuite) TestUpwardFind(c *C) {
 	f := UpwardFind("../tests/success/default", "deps.json")
 	c.Check(f, Equals, "../tests/success/default/deps.json")
@@ -203,3 +296,30 @@ func (s *TestSuite) TestPrintDep(c *C) {
 	PrintDep("NNN", "version", "repo", true)
 	c.Check(buf.String(), Equals, "NNN (version) *\n")
 }
+
+func (s *TestSuite) TestSubject(c *C) {
+	var buf bytes.Buffer
+	l := NewLogger(report.NewJSON(&buf, report.NewQuiet(log.New(ioutil.Discard, "", 0), ioutil.Discard)), false, 0)
+
+	// commands run before the dependency is reported as started are still about it
+	l.Subject("name", "version", "repo")
+	c.Assert(l.Run("", "true"), IsNil)
+
+	var e report.Event
+	c.Assert(json.Unmarshal(buf.Bytes(), &e), IsNil)
+	c.Check(e.Event, Equals, report.EventCommand)
+	c.Check(e.Name, Equals, "name")
+	c.Check(e.Repo, Equals, "repo")
+}
+
+func (s *TestSuite) TestRunErrorKind(c *C) {
+	Mock(new(bytes.Buffer))
+	defer result.Reset()
+
+	err := RunCommand("sh", "-c", "exit 1")
+	c.Check(err.(*result.Error).Kind, Equals, result.VCS)
+
+	err = RunCommand("sh", "-c", "echo 'error: Your local changes to the following files would be overwritten by checkout'; exit 1")
+	c.Check(err.(*result.Error).Kind, Equals, result.Dirty)
+	c.Check(result.ExitCode(), Equals, 4)
+}